@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 
@@ -48,14 +49,54 @@ func main() {
 		return
 	}
 
+	var trackInterval time.Duration
+	if cfg.TrackInterval != "" {
+		trackInterval, err = time.ParseDuration(cfg.TrackInterval)
+		if err != nil {
+			appLogger.Error("Invalid track interval", "error", err)
+			return
+		}
+	}
+
+	var maxStaleness time.Duration
+	if cfg.MaxStaleness != "" {
+		maxStaleness, err = time.ParseDuration(cfg.MaxStaleness)
+		if err != nil {
+			appLogger.Error("Invalid max staleness", "error", err)
+			return
+		}
+	}
+
+	var snapshotMaxAge time.Duration
+	if cfg.SnapshotMaxAge != "" {
+		snapshotMaxAge, err = time.ParseDuration(cfg.SnapshotMaxAge)
+		if err != nil {
+			appLogger.Error("Invalid snapshot max age", "error", err)
+			return
+		}
+	}
+
 	mgr := graph.NewManager(
 		graph.WithLogger(appLogger),
 		graph.WithSpecs(cfg.Specs),
 		graph.WithStorage(store),
 		graph.WithIndexURLs(cfg.RepoDataURLs),
+		graph.WithRepoDataCache(cfg.RepoDataCache),
+		graph.WithMaxStaleness(maxStaleness),
+		graph.WithTrustedKeys(cfg.TrustedKeys, cfg.UnsignedRepos),
+		graph.WithImpactRules(cfg.ImpactRules),
+		graph.WithSnapshotRetention(cfg.SnapshotKeep, snapshotMaxAge),
+		graph.WithTracking(cfg.TrackBranch, trackInterval),
+		graph.WithWebhookSecret(cfg.WebhookSecret),
+		graph.WithRecieverURL(cfg.RecieverURL),
+		graph.WithRepoStatePolicy(cfg.RepoStatePolicy),
 	)
 	mgr.Bootstrap()
 	mgr.Clean()
+	following := cfg.TrackBranch != "" && cfg.WebhookSecret == ""
+	if following {
+		go mgr.Follow()
+	}
 
 	scheduler.SetLogger(appLogger)
 	scheduler.DoCallbacks()
@@ -68,6 +109,8 @@ func main() {
 	scheduler, err := scheduler.NewScheduler(
 		scheduler.WithLogger(appLogger),
 		scheduler.WithCapacityProvider(cap),
+		scheduler.WithPriorities(cfg.Priorities),
+		scheduler.WithBuildTimePriority(cfg.PriorityByBuildTime),
 		scheduler.WithGraphURL("localhost:8080"),
 	)
 	if err != nil {
@@ -78,6 +121,9 @@ func main() {
 
 	srv.Mount("/api/scheduler", scheduler.HTTPEntry())
 	srv.Mount("/api/graph", mgr.HTTPEntry())
+	if cfg.WebhookSecret != "" {
+		srv.Mount("/api/webhook", mgr.WebhookEntry())
+	}
 	go srv.Serve(":8080")
 
 	stop := make(chan os.Signal, 2)
@@ -86,6 +132,9 @@ func main() {
 	<-stop
 
 	appLogger.Info("Shutting down")
+	if following {
+		mgr.StopFollowing()
+	}
 	store.Close()
 	appLogger.Info("Goodbye!")
 }
@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 
@@ -44,16 +45,63 @@ func doGraph(appLogger hclog.Logger, errCh chan error, cfg *config.Config, srv *
 
 	shutdownHandlers = append(shutdownHandlers, func() { store.Close() })
 
+	var trackInterval time.Duration
+	if cfg.TrackInterval != "" {
+		trackInterval, err = time.ParseDuration(cfg.TrackInterval)
+		if err != nil {
+			appLogger.Error("Invalid track interval", "error", err)
+			errCh <- err
+			return
+		}
+	}
+
+	var maxStaleness time.Duration
+	if cfg.MaxStaleness != "" {
+		maxStaleness, err = time.ParseDuration(cfg.MaxStaleness)
+		if err != nil {
+			appLogger.Error("Invalid max staleness", "error", err)
+			errCh <- err
+			return
+		}
+	}
+
+	var snapshotMaxAge time.Duration
+	if cfg.SnapshotMaxAge != "" {
+		snapshotMaxAge, err = time.ParseDuration(cfg.SnapshotMaxAge)
+		if err != nil {
+			appLogger.Error("Invalid snapshot max age", "error", err)
+			errCh <- err
+			return
+		}
+	}
+
 	mgr := graph.NewManager(
 		graph.WithLogger(appLogger),
 		graph.WithSpecs(cfg.Specs),
 		graph.WithStorage(store),
 		graph.WithIndexURLs(cfg.RepoDataURLs),
+		graph.WithRepoDataCache(cfg.RepoDataCache),
+		graph.WithMaxStaleness(maxStaleness),
+		graph.WithTrustedKeys(cfg.TrustedKeys, cfg.UnsignedRepos),
+		graph.WithImpactRules(cfg.ImpactRules),
+		graph.WithSnapshotRetention(cfg.SnapshotKeep, snapshotMaxAge),
+		graph.WithTracking(cfg.TrackBranch, trackInterval),
+		graph.WithWebhookSecret(cfg.WebhookSecret),
+		graph.WithRecieverURL(cfg.RecieverURL),
+		graph.WithRepoStatePolicy(cfg.RepoStatePolicy),
 	)
 	mgr.Bootstrap()
 	mgr.Clean()
 
+	if cfg.TrackBranch != "" && cfg.WebhookSecret == "" {
+		shutdownHandlers = append(shutdownHandlers, mgr.StopFollowing)
+		go mgr.Follow()
+	}
+
 	srv.Mount("/api/graph", mgr.HTTPEntry())
+	if cfg.WebhookSecret != "" {
+		srv.Mount("/api/webhook", mgr.WebhookEntry())
+	}
 }
 
 func doScheduler(appLogger hclog.Logger, errCh chan error, cfg *config.Config, srv *http.Server) {
@@ -69,6 +117,8 @@ func doScheduler(appLogger hclog.Logger, errCh chan error, cfg *config.Config, s
 	scheduler, err := scheduler.NewScheduler(
 		scheduler.WithLogger(appLogger),
 		scheduler.WithCapacityProvider(cap),
+		scheduler.WithPriorities(cfg.Priorities),
+		scheduler.WithBuildTimePriority(cfg.PriorityByBuildTime),
 		scheduler.WithGraphURL("http://localhost:8080/api/graph"),
 	)
 	if err != nil {
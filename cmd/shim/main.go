@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
 
 	"github.com/hashicorp/go-hclog"
 
@@ -71,14 +72,14 @@ func main() {
 		mgr := graph.NewManager(
 			graph.WithLogger(appLogger),
 			graph.WithSpecs([]types.SpecTuple{{"x86_64", "x86_64"}}),
-			graph.WithIndexURLs(map[string]map[string]string{
+			graph.WithIndexURLs(map[string]map[string]repo.Mirrors{
 				"x86_64": {
-					"main":    "http://mirrors.servercentral.com/voidlinux/current/x86_64-repodata",
-					"nonfree": "http://mirrors.servercentral.com/voidlinux/current/nonfree/x86_64-repodata",
+					"main":    {"http://mirrors.servercentral.com/voidlinux/current/x86_64-repodata"},
+					"nonfree": {"http://mirrors.servercentral.com/voidlinux/current/nonfree/x86_64-repodata"},
 				},
 				"armv7l": {
-					"main":    "http://mirrors.servercentral.com/voidlinux/current/armv7l-repodata",
-					"nonfree": "http://mirrors.servercentral.com/voidlinux/current/nonfree/armv7l-repodata",
+					"main":    {"http://mirrors.servercentral.com/voidlinux/current/armv7l-repodata"},
+					"nonfree": {"http://mirrors.servercentral.com/voidlinux/current/nonfree/armv7l-repodata"},
 				},
 			}),
 			graph.WithStorage(store),
@@ -99,6 +100,38 @@ func main() {
 			appLogger.Info("Dispatchable Package", "spec", spec, "package", p)
 		}
 		store.Close()
+	case "export":
+		if len(os.Args) < 4 {
+			appLogger.Error("Usage: export <host:target> <dot|graphml> [pkg] [depth]")
+			return
+		}
+		url, ok := os.LookupEnv("NBUILD_GRAPH_URL")
+		if !ok {
+			url = "http://localhost:8080/api/graph"
+		}
+		client, err := graph.NewAPIClient(appLogger, url)
+		if err != nil {
+			appLogger.Error("Error creating client", "error", err)
+			return
+		}
+		var pkg string
+		depth := 1
+		if len(os.Args) > 4 {
+			pkg = os.Args[4]
+		}
+		if len(os.Args) > 5 {
+			depth, err = strconv.Atoi(os.Args[5])
+			if err != nil {
+				appLogger.Error("Depth must be a number", "error", err)
+				return
+			}
+		}
+		out, err := client.Export(types.SpecTupleFromString(os.Args[2]), os.Args[3], pkg, depth)
+		if err != nil {
+			appLogger.Error("Error exporting graph", "error", err)
+			return
+		}
+		fmt.Print(out)
 	case "nomad-list":
 		scheduler.SetLogger(appLogger)
 		scheduler.DoCallbacks()
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"os"
 
+	"github.com/the-maldridge/nbuild/pkg/repo"
 	"github.com/the-maldridge/nbuild/pkg/types"
 )
 
@@ -13,18 +14,20 @@ import (
 func NewConfig() *Config {
 	return &Config{
 		Specs: []types.SpecTuple{{"x86_64", "x86_64"}},
-		RepoDataURLs: map[string]map[string]string{
+		RepoDataURLs: map[string]map[string]repo.Mirrors{
 			"x86_64": {
-				"main":    "http://repo-fastly.voidlinux.org/current/x86_64-repodata",
-				"nonfree": "http://repo-fastly.voidlinux.org/current/nonfree/x86_64-repodata",
-				"local":   "file://void-packages/hostdir/binpkgs/x86_64-repodata",
+				"main":    {"http://repo-fastly.voidlinux.org/current/x86_64-repodata"},
+				"nonfree": {"http://repo-fastly.voidlinux.org/current/nonfree/x86_64-repodata"},
+				"local":   {"file://void-packages/hostdir/binpkgs/x86_64-repodata"},
 			},
 		},
 		CapacityProvider: "local",
 		BuildSlots: map[string]int{
 			"x86_64:x86_64": 1,
 		},
-		RepoPath: "my-repo",
+		RepoDataCache: "repodata-cache",
+		RepoPath:      "my-repo",
+		SnapshotKeep:  20,
 	}
 }
 
@@ -1,15 +1,70 @@
 package config
 
 import (
+	"github.com/the-maldridge/nbuild/pkg/repo"
 	"github.com/the-maldridge/nbuild/pkg/types"
 )
 
 // Config represents the complete application configuration that
 // nbuild supports.
 type Config struct {
-	Specs            []types.SpecTuple
-	RepoDataURLs     map[string]map[string]string
+	Specs         []types.SpecTuple
+	RepoDataURLs  map[string]map[string]repo.Mirrors
+	RepoDataCache string
+
+	// MaxStaleness refuses to clean against repodata that has not
+	// been loaded from any of its mirrors for this long, which is
+	// parsed as a duration.  Leave it empty to always clean.
+	MaxStaleness string
+
+	// TrustedKeys are the fingerprints of the keys that repodata
+	// must be signed with, as printed by xbps.  Repos named in
+	// UnsignedRepos are exempt.  Leave TrustedKeys empty to load
+	// repodata without checking it.
+	TrustedKeys   []string
+	UnsignedRepos []string
+
 	CapacityProvider string
 	BuildSlots       map[string]int
 	RepoPath         string
+
+	// ImpactRules control how much of the graph is reimported
+	// when files outside of srcpkgs change.  The built in rules
+	// are used if none are provided.
+	ImpactRules []types.ImpactRule
+
+	// Historical snapshots of each graph are retained up to
+	// SnapshotKeep in number and SnapshotMaxAge in age, which is
+	// parsed as a duration.  Zero values disable the limit.
+	SnapshotKeep   int
+	SnapshotMaxAge string
+
+	// Priorities are added to the dispatch score of the named
+	// packages.  When PriorityByBuildTime is set packages that
+	// take longer to build are preferred among equal scores.
+	Priorities          map[string]int
+	PriorityByBuildTime bool
+
+	// TrackBranch is the branch of the remote that the graph
+	// follows automatically.  It is polled every TrackInterval,
+	// which is parsed as a duration.  Leave TrackBranch empty to
+	// only sync when requested via the API.
+	TrackBranch   string
+	TrackInterval string
+
+	// WebhookSecret enables the push webhook, which must be
+	// signed with this secret.  When the webhook is enabled
+	// TrackBranch is synced by pushes instead of being polled,
+	// and pushes are ignored if TrackBranch is not set.
+	WebhookSecret string
+
+	// RepoStatePolicy decides whether dirty packages are built
+	// or cleaned based on how the version in the repo compares
+	// to the template.  Keys are missing, older, equal and newer,
+	// and values are build or clean.
+	RepoStatePolicy map[string]string
+
+	// RecieverURL is the API of the reciever that obsolete
+	// binaries are removed through.
+	RecieverURL string
 }
@@ -1,9 +1,12 @@
 package dispatchable
 
 import (
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/the-maldridge/nbuild/pkg/types"
+	"github.com/the-maldridge/nbuild/pkg/version"
 )
 
 // NewDispatchFinder takes in a set of atoms and works out what can be
@@ -20,38 +23,159 @@ func NewDispatchFinder(opts ...Option) *DispatchFinder {
 // IsDispatchable determines whether a specific package could be dispatched
 // right now.
 func (d *DispatchFinder) IsDispatchable(spec types.SpecTuple, p *types.Package) bool {
-	hAtom := d.atoms[types.SpecTuple{spec.Host, spec.Host}]
-	for hdep := range p.HostDepends {
-		if _, ok := hAtom.Pkgs[hdep]; !ok {
-			d.l.Warn("Host dependency cannot be found in atom", "hdep", hdep, "pkg", p)
-			return false
-		}
-		if hAtom.Pkgs[hdep].Dirty || hAtom.Pkgs[hdep].Failed {
-			return false
+	blockers := d.blockers(spec, p, true)
+	for _, b := range blockers {
+		var msg string
+		switch b.Reason {
+		case ReasonMissing:
+			msg = "Dependency cannot be found in atom"
+		case ReasonUnresolvedVirtual:
+			msg = "Virtual dependency has no provider in atom"
+		case ReasonUnsatisfied:
+			msg = "Dependency version does not satisfy constraint"
+		default:
+			continue
 		}
+		d.l.Warn(msg, "dep", b.Dep, "resolved", b.Package, "scope", b.Scope, "reason", b.Reason, "pkg", p)
+	}
+	// If we get no blockers, all hostdeps, makedeps, deps are clean.
+	return len(blockers) == 0
+}
+
+// Explain works out every dependency that is preventing a package
+// from being dispatched and follows each chain of dirty dependencies
+// down to the packages that are ultimately responsible.
+func (d *DispatchFinder) Explain(spec types.SpecTuple, p *types.Package) Explanation {
+	d.AtomMu.Lock()
+	defer d.AtomMu.Unlock()
+
+	out := Explanation{
+		Spec:    spec,
+		Package: p.Name,
+	}
+
+	visited := map[string]struct{}{spec.String() + "/" + p.Name: {}}
+	roots := make(map[string]Blocker)
+	out.Blockers = d.explain(spec, p, visited, roots)
+	out.Dispatchable = len(out.Blockers) == 0
+
+	out.Roots = make([]Blocker, 0, len(roots))
+	for _, b := range roots {
+		b.Blockers = nil
+		out.Roots = append(out.Roots, b)
 	}
+	sort.Slice(out.Roots, func(i, j int) bool {
+		if out.Roots[i].Package != out.Roots[j].Package {
+			return out.Roots[i].Package < out.Roots[j].Package
+		}
+		return out.Roots[i].Dep < out.Roots[j].Dep
+	})
+	return out
+}
 
-	tAtom := d.atoms[spec]
-	for dep := range p.MakeDepends {
-		if _, ok := tAtom.Pkgs[dep]; !ok {
-			d.l.Warn("Dependency cannot be found in atom", "dep", dep, "pkg", p)
-			return false
+// explain recursively expands the blockers of a package.  Blockers
+// that are only dirty are expanded in turn, anything else is a root
+// cause of the package being stuck.
+func (d *DispatchFinder) explain(spec types.SpecTuple, p *types.Package, visited map[string]struct{}, roots map[string]Blocker) []Blocker {
+	blockers := d.blockers(spec, p, false)
+	for i, b := range blockers {
+		key := b.Spec.String() + "/" + b.Package
+		if b.Reason != ReasonDirty {
+			roots[key+"/"+b.Dep+"/"+b.Reason] = b
+			continue
 		}
-		if tAtom.Pkgs[dep].Dirty || tAtom.Pkgs[dep].Failed {
-			return false
+		if _, ok := visited[key]; ok {
+			continue
+		}
+		visited[key] = struct{}{}
+		bp := d.atoms[b.Spec].Pkgs[b.Package]
+		blockers[i].Blockers = d.explain(b.Spec, bp, visited, roots)
+		if len(blockers[i].Blockers) == 0 {
+			// Dirty, but nothing stands in its way, so
+			// it just needs to be built.
+			roots[key+"/"+b.Dep+"/"+b.Reason] = b
 		}
 	}
-	for dep := range p.Depends {
-		if _, ok := tAtom.Pkgs[dep]; !ok {
-			d.l.Warn("Dependency cannot be found in atom", "dep", dep, "pkg", p)
-			return false
+	return blockers
+}
+
+// blockers returns the dependencies of a package that prevent it
+// from being dispatched.  If first is set then the search stops at
+// the first blocker found.
+func (d *DispatchFinder) blockers(spec types.SpecTuple, p *types.Package, first bool) []Blocker {
+	out := []Blocker{}
+
+	hSpec := types.NewSpecTuple(spec.Host, spec.Host)
+	scopes := []struct {
+		scope string
+		spec  types.SpecTuple
+		kind  types.DepKind
+		deps  map[string]struct{}
+	}{
+		{ScopeHost, hSpec, types.DepHost, p.HostDepends},
+		{ScopeTarget, spec, types.DepMake, p.MakeDepends},
+		{ScopeTarget, spec, types.DepRun, p.Depends},
+	}
+
+	for _, s := range scopes {
+		atom := d.atoms[s.spec]
+		for _, dep := range sortedDeps(s.deps) {
+			dp, reason := d.resolve(atom, dep)
+			if dp != nil && s.spec == spec && dp.Name == p.Name {
+				// Packages may depend on their own
+				// subpackages.
+				continue
+			}
+			if reason == "" {
+				continue
+			}
+
+			b := Blocker{
+				Dep:    dep,
+				Spec:   s.spec,
+				Scope:  s.scope,
+				Kind:   s.kind,
+				Reason: reason,
+			}
+			if dp != nil {
+				b.Package = dp.Name
+			}
+			out = append(out, b)
+			if first {
+				return out
+			}
 		}
-		if tAtom.Pkgs[dep].Dirty || tAtom.Pkgs[dep].Failed {
-			return false
+	}
+	return out
+}
+
+// resolve finds the package that satisfies a dependency within an
+// atom and returns the reason it blocks, if any.
+func (d *DispatchFinder) resolve(atom types.Atom, dep string) (*types.Package, string) {
+	name := version.PatternName(dep)
+	virtual := strings.HasPrefix(dep, "virtual?")
+	if virtual {
+		provider, ok := atom.Virtual[strings.TrimPrefix(dep, "virtual?")]
+		if !ok {
+			return nil, ReasonUnresolvedVirtual
 		}
+		name = provider
+	}
+
+	dp, ok := atom.Pkgs[name]
+	switch {
+	case !ok:
+		return nil, ReasonMissing
+	case !virtual && !version.Satisfies(name, dp.Version, dep):
+		return dp, ReasonUnsatisfied
+	case dp.Failed:
+		return dp, ReasonFailed
+	case dp.Dirty && !dp.Buildable:
+		return dp, ReasonUnbuildable
+	case dp.Dirty:
+		return dp, ReasonDirty
 	}
-	// If we get this far, all hostdeps, makedeps, deps are clean.
-	return true
+	return dp, ""
 }
 
 // ImmediatelyDispatchable returns a map of tuples -> packages that can be
@@ -70,10 +194,19 @@ func (d *DispatchFinder) ImmediatelyDispatchable() map[types.SpecTuple][]*types.
 		}
 		dispatchable[spec] = make([]*types.Package, 0)
 		for _, pkg := range atom.Pkgs {
-			if !pkg.Failed && pkg.Dirty && d.IsDispatchable(spec, pkg) {
+			if !pkg.Failed && pkg.Dirty && pkg.Buildable && d.IsDispatchable(spec, pkg) {
 				dispatchable[spec] = append(dispatchable[spec], pkg)
 			}
 		}
 	}
 	return dispatchable
 }
+
+func sortedDeps(deps map[string]struct{}) []string {
+	out := make([]string, 0, len(deps))
+	for dep := range deps {
+		out = append(out, dep)
+	}
+	sort.Strings(out)
+	return out
+}
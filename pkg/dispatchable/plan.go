@@ -0,0 +1,133 @@
+package dispatchable
+
+import (
+	"sort"
+
+	"github.com/the-maldridge/nbuild/pkg/types"
+)
+
+// Plan computes a complete build plan for the dirty packages of a
+// spec.  Dirty host packages that the spec needs are included, so a
+// plan for a cross spec also covers the native builds it waits on.
+// Packages are layered into waves such that every package in a wave
+// depends only on packages that are clean or in an earlier wave.
+// Packages that can never be built with the graph as it is are
+// listed separately along with the reason.
+func (d *DispatchFinder) Plan(spec types.SpecTuple) BuildPlan {
+	d.AtomMu.Lock()
+	defer d.AtomMu.Unlock()
+
+	plan := BuildPlan{
+		Spec:    spec,
+		Waves:   [][]PlanItem{},
+		Blocked: []BlockedItem{},
+	}
+
+	deps := make(map[PlanItem][]PlanItem)
+	blocked := make(map[PlanItem]string)
+
+	var visit func(PlanItem)
+	visit = func(n PlanItem) {
+		if _, ok := deps[n]; ok {
+			return
+		}
+		deps[n] = []PlanItem{}
+		p := d.atoms[n.Spec].Pkgs[n.Package]
+		for _, b := range d.blockers(n.Spec, p, false) {
+			if b.Reason != ReasonDirty {
+				if _, ok := blocked[n]; !ok {
+					blocked[n] = b.Dep + " is " + b.Reason
+				}
+				continue
+			}
+			dep := PlanItem{Spec: b.Spec, Package: b.Package}
+			deps[n] = append(deps[n], dep)
+			visit(dep)
+		}
+	}
+
+	for name, p := range d.atoms[spec].Pkgs {
+		if name != p.Name || !p.Dirty || p.Failed || !p.Buildable {
+			continue
+		}
+		visit(PlanItem{Spec: spec, Package: name})
+	}
+
+	// Anything that waits on a blocked package is blocked as well.
+	for changed := true; changed; {
+		changed = false
+		for n, nd := range deps {
+			if _, ok := blocked[n]; ok {
+				continue
+			}
+			for _, dep := range nd {
+				if _, ok := blocked[dep]; ok {
+					blocked[n] = "waits on blocked " + dep.Package
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	done := make(map[PlanItem]struct{})
+	for {
+		wave := []PlanItem{}
+		for n, nd := range deps {
+			if _, ok := blocked[n]; ok {
+				continue
+			}
+			if _, ok := done[n]; ok {
+				continue
+			}
+			ready := true
+			for _, dep := range nd {
+				if _, ok := done[dep]; !ok {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				wave = append(wave, n)
+			}
+		}
+		if len(wave) == 0 {
+			break
+		}
+		for _, n := range wave {
+			done[n] = struct{}{}
+		}
+		sortItems(wave)
+		plan.Waves = append(plan.Waves, wave)
+	}
+
+	// Whatever is left over is part of, or waits on, a cycle.
+	for n := range deps {
+		if _, ok := done[n]; ok {
+			continue
+		}
+		if _, ok := blocked[n]; !ok {
+			blocked[n] = "dependency cycle"
+		}
+	}
+
+	for n, reason := range blocked {
+		plan.Blocked = append(plan.Blocked, BlockedItem{PlanItem: n, Reason: reason})
+	}
+	sort.Slice(plan.Blocked, func(i, j int) bool {
+		return lessItem(plan.Blocked[i].PlanItem, plan.Blocked[j].PlanItem)
+	})
+	plan.Builds = len(done)
+	return plan
+}
+
+func sortItems(items []PlanItem) {
+	sort.Slice(items, func(i, j int) bool { return lessItem(items[i], items[j]) })
+}
+
+func lessItem(a, b PlanItem) bool {
+	if a.Spec != b.Spec {
+		return a.Spec.String() < b.Spec.String()
+	}
+	return a.Package < b.Package
+}
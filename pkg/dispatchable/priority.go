@@ -0,0 +1,61 @@
+package dispatchable
+
+import (
+	"github.com/the-maldridge/nbuild/pkg/types"
+)
+
+// Scores rates every dispatchable package by the number of dirty
+// packages, across all specs, that transitively wait on it.  Building
+// the packages with the highest score first unblocks the most work.
+func (d *DispatchFinder) Scores() map[types.SpecTuple]map[string]int {
+	d.AtomMu.Lock()
+	defer d.AtomMu.Unlock()
+
+	// Build the reverse edges between dirty packages.
+	waiters := make(map[PlanItem][]PlanItem)
+	ready := []PlanItem{}
+	for spec, atom := range d.atoms {
+		if _, ok := d.atoms[types.NewSpecTuple(spec.Host, spec.Host)]; !ok {
+			continue
+		}
+		for name, p := range atom.Pkgs {
+			if name != p.Name || !p.Dirty || p.Failed || !p.Buildable {
+				continue
+			}
+			n := PlanItem{Spec: spec, Package: name}
+			blockers := d.blockers(spec, p, false)
+			if len(blockers) == 0 {
+				ready = append(ready, n)
+			}
+			for _, b := range blockers {
+				if b.Reason != ReasonDirty {
+					continue
+				}
+				dep := PlanItem{Spec: b.Spec, Package: b.Package}
+				waiters[dep] = append(waiters[dep], n)
+			}
+		}
+	}
+
+	scores := make(map[types.SpecTuple]map[string]int)
+	for _, n := range ready {
+		seen := map[PlanItem]struct{}{n: {}}
+		queue := []PlanItem{n}
+		for len(queue) > 0 {
+			cur := queue[0]
+			queue = queue[1:]
+			for _, w := range waiters[cur] {
+				if _, ok := seen[w]; ok {
+					continue
+				}
+				seen[w] = struct{}{}
+				queue = append(queue, w)
+			}
+		}
+		if _, ok := scores[n.Spec]; !ok {
+			scores[n.Spec] = make(map[string]int)
+		}
+		scores[n.Spec][n.Package] = len(seen) - 1
+	}
+	return scores
+}
@@ -17,6 +17,78 @@ type DispatchFinder struct {
 	atoms map[types.SpecTuple]types.Atom
 }
 
+// Scopes describe which atom a blocking dependency was looked up in.
+const (
+	ScopeHost   = "host"
+	ScopeTarget = "target"
+)
+
+// Reasons explain why a dependency blocks a package.
+const (
+	ReasonDirty             = "dirty"
+	ReasonFailed            = "failed"
+	ReasonMissing           = "missing"
+	ReasonUnresolvedVirtual = "unresolved-virtual"
+	ReasonUnsatisfied       = "unsatisfied"
+	ReasonUnbuildable       = "unbuildable"
+)
+
+// A Blocker is a single dependency that prevents a package from being
+// dispatched.
+type Blocker struct {
+	// Dep is the dependency as it is written in the template.
+	Dep string
+
+	// Package is the name of the package that was resolved from
+	// Dep, if one could be found.
+	Package string
+
+	Spec   types.SpecTuple
+	Scope  string
+	Kind   types.DepKind
+	Reason string
+
+	// Blockers contains the blockers of this dependency in turn
+	// if it is dirty.
+	Blockers []Blocker `json:",omitempty"`
+}
+
+// Explanation describes why a package is or is not dispatchable.
+type Explanation struct {
+	Spec         types.SpecTuple
+	Package      string
+	Dispatchable bool
+
+	// Blockers are the direct dependencies that block the
+	// package, each expanded down to its own blockers.
+	Blockers []Blocker
+
+	// Roots are the blockers at the bottom of every chain, which
+	// are the packages that need attention to unstick this one.
+	Roots []Blocker
+}
+
+// A PlanItem is a single build within a plan.
+type PlanItem struct {
+	Spec    types.SpecTuple
+	Package string
+}
+
+// A BlockedItem is a build that cannot happen and the reason why.
+type BlockedItem struct {
+	PlanItem
+	Reason string
+}
+
+// BuildPlan is an ordered plan for building all of the dirty packages
+// of a spec.  The builds within each wave can run in parallel.
+type BuildPlan struct {
+	Spec    types.SpecTuple
+	Builds  int
+	Waves   [][]PlanItem
+	Blocked []BlockedItem
+}
+
 // Option allows various config values to be passed in using a slice
 // of option.
 type Option func(*DispatchFinder)
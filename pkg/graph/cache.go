@@ -0,0 +1,252 @@
+package graph
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/the-maldridge/nbuild/pkg/storage"
+	"github.com/the-maldridge/nbuild/pkg/types"
+)
+
+// commonDumpInputs lists the paths outside of srcpkgs that can
+// influence the output of dbulk-dump for every package.  These are
+// the paths that the impact rules treat as full, and directories are
+// hashed recursively.  The cross profiles only matter to cross specs
+// but are hashed for every spec.
+var commonDumpInputs = []string{
+	"xbps-src",
+	"etc/defaults.conf",
+	"etc/defaults.virtual",
+	"common/xbps-src",
+	"common/environment",
+	"common/shlibs",
+	"common/cross-profiles",
+}
+
+// perPackageDumpInputs are beneath commonDumpInputs but are hashed
+// only for the packages that use them.
+var perPackageDumpInputs = map[string]struct{}{
+	"common/environment/build-style": {},
+}
+
+// newDumpCache returns a cache that persists to the provided store.
+// The store may be nil in which case every lookup is a miss and
+// nothing is retained.
+func newDumpCache(l hclog.Logger, s storage.Storage) *dumpCache {
+	return &dumpCache{
+		l:       l.Named("dumpcache"),
+		storage: s,
+		mu:      new(sync.Mutex),
+		stats:   make(map[string]*CacheStats),
+	}
+}
+
+// Get returns the cached dump for the named package if one exists
+// and was produced from inputs with the same hash.
+func (c *dumpCache) Get(spec types.SpecTuple, name, sum string) ([]byte, bool) {
+	if c.storage == nil || sum == "" {
+		c.count(spec, false)
+		return nil, false
+	}
+
+	b, err := c.storage.Get(c.key(spec, name))
+	if err != nil || b == nil {
+		if err != nil {
+			c.l.Warn("Error reading cached dump", "spec", spec, "package", name, "error", err)
+		}
+		c.count(spec, false)
+		return nil, false
+	}
+
+	entry := cachedDump{}
+	if err := json.Unmarshal(b, &entry); err != nil {
+		c.l.Warn("Error decoding cached dump", "spec", spec, "package", name, "error", err)
+		c.count(spec, false)
+		return nil, false
+	}
+	if entry.Sum != sum {
+		c.count(spec, false)
+		return nil, false
+	}
+	c.count(spec, true)
+	return entry.Dump, true
+}
+
+// Put stores a dump for the named package along with the hash of the
+// inputs that produced it.
+func (c *dumpCache) Put(spec types.SpecTuple, name, sum string, dump []byte) {
+	if c.storage == nil || sum == "" {
+		return
+	}
+
+	b, err := json.Marshal(cachedDump{Sum: sum, Dump: dump})
+	if err != nil {
+		c.l.Warn("Error encoding dump", "spec", spec, "package", name, "error", err)
+		return
+	}
+	if err := c.storage.Put(c.key(spec, name), b); err != nil {
+		c.l.Warn("Error writing cached dump", "spec", spec, "package", name, "error", err)
+	}
+}
+
+// Stats returns a copy of the hit and miss counters for every spec
+// that has used the cache.
+func (c *dumpCache) Stats() map[string]CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make(map[string]CacheStats, len(c.stats))
+	for spec, s := range c.stats {
+		out[spec] = *s
+	}
+	return out
+}
+
+func (c *dumpCache) count(spec types.SpecTuple, hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.stats[spec.String()]
+	if !ok {
+		s = new(CacheStats)
+		c.stats[spec.String()] = s
+	}
+	if hit {
+		s.Hits++
+	} else {
+		s.Misses++
+	}
+}
+
+func (c *dumpCache) key(spec types.SpecTuple, name string) []byte {
+	return []byte(path.Join("dumpcache", spec.String(), name))
+}
+
+// commonHash computes the hash of all inputs shared between every
+// package in the tree.  It is computed once per import rather than
+// once per package.
+func (t *PkgGraph) commonHash() string {
+	h := sha256.New()
+	for _, input := range commonDumpInputs {
+		root := filepath.Join(t.basePath, input)
+		if _, err := os.Stat(root); err != nil {
+			// Keep the position of the input in the hash
+			// so that files appearing later are noticed.
+			io.WriteString(h, input+"\x00")
+			continue
+		}
+		// Walk visits files in lexical order, so the hash is
+		// stable.
+		filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			rel, _ := filepath.Rel(t.basePath, p)
+			if _, ok := perPackageDumpInputs[filepath.ToSlash(rel)]; ok && info.IsDir() {
+				return filepath.SkipDir
+			}
+			if info.Mode().IsRegular() {
+				hashFile(h, t.basePath, p)
+			}
+			return nil
+		})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// inputHash computes the hash of everything that can influence the
+// dump of a single package: the common inputs, every file in the
+// package's directory, and the build-style and build-helper scripts
+// the template pulls in.
+func (t *PkgGraph) inputHash(name, common string) (string, error) {
+	h := sha256.New()
+	io.WriteString(h, common)
+
+	pkgdir := filepath.Join(t.basePath, "srcpkgs", name)
+	err := filepath.Walk(pkgdir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		hashFile(h, t.basePath, p)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	vars := templateVars(filepath.Join(pkgdir, "template"), "build_style", "build_helper")
+	for _, style := range strings.Fields(vars["build_style"]) {
+		hashFile(h, t.basePath, filepath.Join(t.basePath, "common", "build-style", style+".sh"))
+		hashFile(h, t.basePath, filepath.Join(t.basePath, "common", "environment", "build-style", style+".sh"))
+	}
+	for _, helper := range strings.Fields(vars["build_helper"]) {
+		hashFile(h, t.basePath, filepath.Join(t.basePath, "common", "build-helper", helper+".sh"))
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashFile writes the name and content of a file into the hash.
+// Files that cannot be read are recorded as missing so that their
+// later appearance changes the hash.
+func hashFile(h hash.Hash, base, p string) {
+	rel, err := filepath.Rel(base, p)
+	if err != nil {
+		rel = p
+	}
+	io.WriteString(h, rel+"\x00")
+
+	f, err := os.Open(p)
+	if err != nil {
+		io.WriteString(h, "missing\x00")
+		return
+	}
+	defer f.Close()
+	io.Copy(h, f)
+	io.WriteString(h, "\x00")
+}
+
+// templateVars performs a shallow scan of a template for simple
+// top-level assignments to the requested variables.  It does not
+// evaluate the shell, so only literal values are returned.
+func templateVars(p string, keys ...string) map[string]string {
+	out := make(map[string]string)
+	f, err := os.Open(p)
+	if err != nil {
+		return out
+	}
+	defer f.Close()
+
+	want := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		want[k] = struct{}{}
+	}
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if _, ok := want[parts[0]]; !ok {
+			continue
+		}
+		out[parts[0]] = strings.Trim(parts[1], "\"'")
+	}
+	return out
+}
@@ -1,11 +1,15 @@
 package graph
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -30,6 +34,13 @@ func NewAPIClient(l hclog.Logger, url string) (*APIClient, error) {
 
 // General function to recieve a response
 func (c *APIClient) do(endpoint string, method string) (string, error) {
+	_, body, err := c.request(endpoint, method)
+	return body, err
+}
+
+// request performs a request and returns the status code along with
+// the body of the response.
+func (c *APIClient) request(endpoint string, method string) (int, string, error) {
 	var resp *http.Response
 	var err error
 	fullURL := c.url + endpoint
@@ -40,10 +51,11 @@ func (c *APIClient) do(endpoint string, method string) (string, error) {
 		resp, err = c.hClient.Post(fullURL, "application/json", bytes.NewBuffer([]byte("{}")))
 	default:
 		c.l.Warn("Unknown method", "method", method, "endpoint", endpoint)
+		return 0, "", errors.New("unknown method")
 	}
 	if err != nil {
 		c.l.Warn("Unable to recieve from API", "endpoint", endpoint, "method", method, "err", err)
-		return "", err
+		return 0, "", err
 	}
 	defer resp.Body.Close()
 
@@ -52,10 +64,10 @@ func (c *APIClient) do(endpoint string, method string) (string, error) {
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		c.l.Warn("Unable to read response from API", "endpoint", endpoint, "method", method, "err", err)
-		return "", err
+		return 0, "", err
 	}
 
-	return string(body), nil
+	return resp.StatusCode, string(body), nil
 }
 
 // Clean target via API
@@ -85,10 +97,15 @@ func (c *APIClient) SyncTo(rev string) error {
 type Dispatchable struct {
 	Pkgs map[types.SpecTuple][]string
 	Rev  string
+
+	// Scores holds the number of dirty packages waiting on
+	// each dispatchable package.
+	Scores map[types.SpecTuple]map[string]int
 }
 
 type rawDispatchable struct {
 	Pkgs     map[string][]string
+	Scores   map[string]map[string]int
 	Revision string
 }
 
@@ -113,9 +130,102 @@ func (c *APIClient) GetDispatchable() (*Dispatchable, error) {
 		pkgs[specTuple] = list
 	}
 
+	scores := make(map[types.SpecTuple]map[string]int)
+	for tuple, s := range data.Scores {
+		scores[types.SpecTupleFromString(tuple)] = s
+	}
+
 	result := Dispatchable{
-		Pkgs: pkgs,
-		Rev:  data.Revision,
+		Pkgs:   pkgs,
+		Rev:    data.Revision,
+		Scores: scores,
 	}
 	return &result, nil
 }
+
+// Export requests a rendering of a spec graph in the given format.
+// If pkg is not empty only the packages within depth edges of it are
+// included.
+func (c *APIClient) Export(spec types.SpecTuple, format, pkg string, depth int) (string, error) {
+	endpoint := "/export/" + spec.Host + "/" + spec.Target + "/" + format
+	if pkg != "" {
+		endpoint += "?pkg=" + url.QueryEscape(pkg) + "&depth=" + strconv.Itoa(depth)
+	}
+	code, body, err := c.request(endpoint, "GET")
+	if err != nil {
+		return "", err
+	}
+	if code != http.StatusOK {
+		// Errors are returned as JSON rather than in the
+		// requested format.
+		var errText map[string]string
+		if json.Unmarshal([]byte(body), &errText) == nil && errText["Error"] != "" {
+			return "", errors.New(errText["Error"])
+		}
+		return "", errors.New("unexpected status: " + http.StatusText(code))
+	}
+	return body, nil
+}
+
+// Subscribe opens the event stream of a remote graph server.  If any
+// types are listed only events of those types are delivered.  The
+// returned channel is closed when the stream ends or ctx is
+// cancelled.
+func (c *APIClient) Subscribe(ctx context.Context, eventTypes ...string) (<-chan Event, error) {
+	endpoint := c.url + "/events"
+	if len(eventTypes) > 0 {
+		endpoint += "?types=" + url.QueryEscape(strings.Join(eventTypes, ","))
+	}
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+
+	// The stream stays open indefinitely, so the client with a
+	// timeout can't be used.
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		c.l.Warn("Unable to subscribe to events", "err", err)
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		c.l.Warn("Unable to subscribe to events", "status", resp.Status)
+		return nil, errors.New("unexpected status: " + resp.Status)
+	}
+
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		defer resp.Body.Close()
+
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		var data string
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "data:"):
+				data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			case line == "" && data != "":
+				e := Event{}
+				if err := json.Unmarshal([]byte(data), &e); err != nil {
+					c.l.Warn("Error unmarshalling event", "err", err)
+				} else {
+					select {
+					case out <- e:
+					case <-ctx.Done():
+						return
+					}
+				}
+				data = ""
+			}
+		}
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			c.l.Warn("Event stream ended", "err", err)
+		}
+	}()
+	return out, nil
+}
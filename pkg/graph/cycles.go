@@ -0,0 +1,124 @@
+package graph
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/the-maldridge/nbuild/pkg/types"
+)
+
+// FindCycles computes the strongly connected components of the graph
+// and records every component of more than one package on the atom.
+// Host dependencies are only considered for native graphs since on a
+// cross graph they refer to packages in a different graph.
+func (t *PkgGraph) FindCycles() [][]string {
+	var cycles [][]string
+	t.update(func(s *graphState) {
+		cycles = t.findCycles(s.atom)
+		s.atom.Cycles = cycles
+	})
+	return cycles
+}
+
+// findCycles computes the cycles within an atom.
+func (t *PkgGraph) findCycles(a types.Atom) [][]string {
+	cycles := stronglyConnected(edges(a))
+	if len(cycles) > 0 {
+		t.l.Warn("Dependency cycles detected", "count", len(cycles))
+		for _, c := range cycles {
+			t.l.Debug("Dependency cycle", "pkgs", strings.Join(c, " "))
+		}
+	}
+	return cycles
+}
+
+// edges returns the adjacency list of source packages within an
+// atom.
+func edges(a types.Atom) map[string][]string {
+	out := make(map[string][]string)
+	for name, p := range a.Pkgs {
+		if name == p.Name {
+			out[name] = nil
+		}
+	}
+	for _, e := range atomEdges(a) {
+		out[e.From] = append(out[e.From], e.To)
+	}
+	return out
+}
+
+// resolveDep resolves a dependency string to the package in an atom
+// that provides it, following virtual packages to their default
+// provider.
+func resolveDep(a types.Atom, dep string) *types.Package {
+	name := dep
+	if strings.HasPrefix(dep, "virtual?") {
+		name = a.Virtual[strings.TrimPrefix(dep, "virtual?")]
+	}
+	return a.Pkgs[depName(name)]
+}
+
+// stronglyConnected uses Tarjan's algorithm to find all components of
+// the graph that contain more than a single node.  Each component is
+// sorted, and the components are sorted by their first member, so
+// that the output is stable.
+func stronglyConnected(edges map[string][]string) [][]string {
+	index := 0
+	indices := make(map[string]int, len(edges))
+	lowlink := make(map[string]int, len(edges))
+	onStack := make(map[string]bool)
+	stack := []string{}
+	out := [][]string{}
+
+	var connect func(string)
+	connect = func(v string) {
+		indices[v] = index
+		lowlink[v] = index
+		index++
+		stack = append(stack, v)
+		onStack[v] = true
+
+		for _, w := range edges[v] {
+			if _, seen := indices[w]; !seen {
+				connect(w)
+				if lowlink[w] < lowlink[v] {
+					lowlink[v] = lowlink[w]
+				}
+			} else if onStack[w] && indices[w] < lowlink[v] {
+				lowlink[v] = indices[w]
+			}
+		}
+
+		if lowlink[v] != indices[v] {
+			return
+		}
+		component := []string{}
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			component = append(component, w)
+			if w == v {
+				break
+			}
+		}
+		if len(component) > 1 {
+			sort.Strings(component)
+			out = append(out, component)
+		}
+	}
+
+	nodes := make([]string, 0, len(edges))
+	for v := range edges {
+		nodes = append(nodes, v)
+	}
+	sort.Strings(nodes)
+	for _, v := range nodes {
+		if _, seen := indices[v]; !seen {
+			connect(v)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i][0] < out[j][0] })
+	return out
+}
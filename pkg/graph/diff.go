@@ -0,0 +1,106 @@
+package graph
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/the-maldridge/nbuild/pkg/types"
+)
+
+// DiffAtoms computes the changes needed to go from one atom to
+// another.  Only source packages are compared, subpackages follow
+// their parent.
+func DiffAtoms(from, to types.Atom) Diff {
+	d := Diff{
+		Spec: to.Spec,
+		From: from.Rev,
+		To:   to.Rev,
+	}
+
+	for name, tp := range to.Pkgs {
+		if name != tp.Name {
+			continue
+		}
+		fp, ok := from.Pkgs[name]
+		if !ok || fp.Name != name {
+			d.Added = append(d.Added, name)
+			if tp.Dirty {
+				d.Dirtied = append(d.Dirtied, name)
+			}
+			continue
+		}
+		if fp.Version != tp.Version {
+			d.Changed = append(d.Changed, VersionChange{Name: name, From: fp.Version, To: tp.Version})
+		}
+		switch {
+		case !fp.Dirty && tp.Dirty:
+			d.Dirtied = append(d.Dirtied, name)
+		case fp.Dirty && !tp.Dirty:
+			d.Cleaned = append(d.Cleaned, name)
+		}
+	}
+	for name, fp := range from.Pkgs {
+		if name != fp.Name {
+			continue
+		}
+		if tp, ok := to.Pkgs[name]; !ok || tp.Name != name {
+			d.Removed = append(d.Removed, name)
+		}
+	}
+
+	fromEdges := make(map[Edge]struct{})
+	for _, e := range atomEdges(from) {
+		fromEdges[e] = struct{}{}
+	}
+	for _, e := range atomEdges(to) {
+		if _, ok := fromEdges[e]; ok {
+			delete(fromEdges, e)
+			continue
+		}
+		d.EdgesAdded = append(d.EdgesAdded, e)
+	}
+	for e := range fromEdges {
+		d.EdgesRemoved = append(d.EdgesRemoved, e)
+	}
+
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Dirtied)
+	sort.Strings(d.Cleaned)
+	sort.Slice(d.Changed, func(i, j int) bool { return d.Changed[i].Name < d.Changed[j].Name })
+	sortEdges(d.EdgesAdded)
+	sortEdges(d.EdgesRemoved)
+	return d
+}
+
+// Summary returns the counts of each kind of change in a form that is
+// convenient to log.
+func (d Diff) Summary() []interface{} {
+	return []interface{}{
+		"spec", d.Spec,
+		"from", d.From,
+		"to", d.To,
+		"added", len(d.Added),
+		"removed", len(d.Removed),
+		"changed", len(d.Changed),
+		"edgesAdded", len(d.EdgesAdded),
+		"edgesRemoved", len(d.EdgesRemoved),
+		"dirtied", len(d.Dirtied),
+		"cleaned", len(d.Cleaned),
+	}
+}
+
+// atomAt returns the atom of the graph at a revision if it is held in
+// memory, which is only the case for the current revision and the
+// one before the most recent sync.  An empty rev selects the previous
+// revision if prev is set, and the current one otherwise.
+func (t *PkgGraph) atomAt(rev string, prev bool) (types.Atom, error) {
+	s := t.load()
+	if (rev == "" && !prev) || rev == s.atom.Rev {
+		return s.atom, nil
+	}
+	if s.prev != nil && (rev == "" || rev == s.prev.Rev) {
+		return *s.prev, nil
+	}
+	return types.Atom{}, errors.New("revision not available")
+}
@@ -0,0 +1,218 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/the-maldridge/nbuild/pkg/types"
+)
+
+// Types of event that are sent to subscribers.
+const (
+	EventDirty        = "dirty"
+	EventCleaned      = "cleaned"
+	EventFailed       = "failed"
+	EventUnfailed     = "unfailed"
+	EventSyncStarted  = "sync-started"
+	EventSyncFinished = "sync-finished"
+	EventImportError  = "import-error"
+	EventDispatchable = "dispatchable-changed"
+)
+
+// subscriberBuffer is the number of events that may be waiting for a
+// subscriber before further events are dropped.
+const subscriberBuffer = 1024
+
+// keepaliveInterval is how often an idle event stream is written to
+// so that proxies do not close it.
+const keepaliveInterval = 30 * time.Second
+
+func newEventBroker(l hclog.Logger) *eventBroker {
+	return &eventBroker{
+		l:    l.Named("events"),
+		mu:   new(sync.Mutex),
+		subs: make(map[chan Event]struct{}),
+	}
+}
+
+// subscribe registers a new subscriber and returns the channel that
+// events will be delivered on.
+func (b *eventBroker) subscribe() chan Event {
+	ch := make(chan Event, subscriberBuffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+// unsubscribe removes a subscriber and closes its channel.
+func (b *eventBroker) unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; !ok {
+		return
+	}
+	delete(b.subs, ch)
+	close(ch)
+	if len(b.subs) == 0 {
+		// Nobody is watching the dispatchable set, so
+		// the next subscriber must be told about it.
+		b.dispatchable = nil
+	}
+}
+
+// publish sends an event to every subscriber.  Subscribers that are
+// not keeping up miss the event rather than blocking the graph.
+func (b *eventBroker) publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			b.l.Warn("Subscriber is not keeping up, dropping event", "type", e.Type)
+		}
+	}
+}
+
+// count returns the number of subscribers.
+func (b *eventBroker) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// notify queues an event to be sent once the state is published.
+func (s *graphState) notify(e Event) {
+	s.pending = append(s.pending, e)
+}
+
+// emit sends the events of a state that has been published.
+func (t *PkgGraph) emit(events []Event) {
+	if t.events == nil {
+		return
+	}
+	for _, e := range events {
+		e.Spec = t.spec.String()
+		t.events.publish(e)
+	}
+}
+
+// checkDispatchable sends an event if the set of dispatchable
+// packages has changed since it was last checked.  It does nothing
+// while there are no subscribers.
+func (m *Manager) checkDispatchable() {
+	if m.events.count() == 0 {
+		return
+	}
+	cur := dispatchNames(m.GetDispatchable())
+
+	m.events.mu.Lock()
+	changed := !reflect.DeepEqual(cur, m.events.dispatchable)
+	if changed {
+		m.events.dispatchable = cur
+	}
+	m.events.mu.Unlock()
+
+	if changed {
+		m.events.publish(Event{Type: EventDispatchable, Rev: m.Rev(), Pkgs: cur})
+	}
+}
+
+// dispatchNames reduces the dispatchable packages to a sorted list of
+// unique names per spec.
+func dispatchNames(pkgs map[types.SpecTuple][]*types.Package) map[string][]string {
+	out := make(map[string][]string, len(pkgs))
+	for spec, list := range pkgs {
+		// dedup the list (subpkgs add the parent multiple
+		// times)
+		dispatch := make(map[string]struct{}, len(list))
+		for _, pkg := range list {
+			dispatch[pkg.Name] = struct{}{}
+		}
+		names := make([]string, 0, len(dispatch))
+		for name := range dispatch {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		out[spec.String()] = names
+	}
+	return out
+}
+
+func (m *Manager) httpEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	want := make(map[string]struct{})
+	if t := r.URL.Query().Get("types"); t != "" {
+		for _, e := range strings.Split(t, ",") {
+			want[e] = struct{}{}
+		}
+	}
+
+	ch := m.events.subscribe()
+	defer m.events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Subscribers start out knowing the dispatchable set.
+	cur := dispatchNames(m.GetDispatchable())
+	m.events.mu.Lock()
+	if m.events.dispatchable == nil {
+		m.events.dispatchable = cur
+	}
+	m.events.mu.Unlock()
+	if err := writeEvent(w, Event{Type: EventDispatchable, Rev: m.Rev(), Pkgs: cur, Time: time.Now()}); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case e := <-ch:
+			if _, ok := want[e.Type]; len(want) > 0 && !ok {
+				continue
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes a single event in the text/event-stream format.
+func writeEvent(w http.ResponseWriter, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+	return err
+}
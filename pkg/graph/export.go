@@ -0,0 +1,246 @@
+package graph
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/the-maldridge/nbuild/pkg/types"
+)
+
+// Formats that a graph can be exported in.
+const (
+	FormatDOT     = "dot"
+	FormatGraphML = "graphml"
+)
+
+// Export renders the graph, or the neighborhood of root out to depth
+// edges in either direction if root is not empty, in the requested
+// format.
+func (t *PkgGraph) Export(w io.Writer, format, root string, depth int) error {
+	a := t.GetAtom()
+	nodes, edges, err := subgraph(a, root, depth)
+	if err != nil {
+		return err
+	}
+
+	name := a.Spec.String()
+	switch format {
+	case FormatDOT:
+		return writeDOT(w, name, nodes, edges)
+	case FormatGraphML:
+		return writeGraphML(w, name, nodes, edges)
+	}
+	return errors.New("unknown export format")
+}
+
+// atomEdges computes the dependency edges between the source packages
+// of an atom.  Host edges are only included for native graphs since
+// on a cross graph they point into a different graph.
+func atomEdges(a types.Atom) []Edge {
+	out := []Edge{}
+	for name, p := range a.Pkgs {
+		if name != p.Name {
+			continue
+		}
+		seen := make(map[Edge]struct{})
+		for kind, deps := range p.DepsByKind() {
+			if kind == types.DepHost && !a.Spec.Native() {
+				continue
+			}
+			for dep := range deps {
+				dp := resolveDep(a, dep)
+				if dp == nil || dp.Name == p.Name {
+					continue
+				}
+				e := Edge{From: p.Name, To: dp.Name, Kind: kind}
+				if _, ok := seen[e]; ok {
+					continue
+				}
+				seen[e] = struct{}{}
+				out = append(out, e)
+			}
+		}
+	}
+	sortEdges(out)
+	return out
+}
+
+// subgraph returns the source packages and edges to export.
+func subgraph(a types.Atom, root string, depth int) (map[string]*types.Package, []Edge, error) {
+	edges := atomEdges(a)
+	nodes := make(map[string]*types.Package)
+	if root == "" {
+		for name, p := range a.Pkgs {
+			if name == p.Name {
+				nodes[name] = p
+			}
+		}
+		return nodes, edges, nil
+	}
+
+	rp, ok := a.Pkgs[root]
+	if !ok {
+		return nil, nil, errors.New("pkg not found")
+	}
+
+	adjacent := make(map[string][]string)
+	for _, e := range edges {
+		adjacent[e.From] = append(adjacent[e.From], e.To)
+		adjacent[e.To] = append(adjacent[e.To], e.From)
+	}
+
+	nodes[rp.Name] = rp
+	frontier := []string{rp.Name}
+	for i := 0; i < depth && len(frontier) > 0; i++ {
+		next := []string{}
+		for _, n := range frontier {
+			for _, adj := range adjacent[n] {
+				if _, ok := nodes[adj]; ok {
+					continue
+				}
+				nodes[adj] = a.Pkgs[adj]
+				next = append(next, adj)
+			}
+		}
+		frontier = next
+	}
+
+	kept := []Edge{}
+	for _, e := range edges {
+		_, from := nodes[e.From]
+		_, to := nodes[e.To]
+		if from && to {
+			kept = append(kept, e)
+		}
+	}
+	return nodes, kept, nil
+}
+
+// nodeColor picks the color a package is drawn in based on its state.
+func nodeColor(p *types.Package) string {
+	switch {
+	case p.Failed:
+		return "red"
+	case !p.Buildable:
+		return "gray"
+	case p.Dirty:
+		return "orange"
+	}
+	return "green"
+}
+
+func writeDOT(w io.Writer, name string, nodes map[string]*types.Package, edges []Edge) error {
+	if _, err := fmt.Fprintf(w, "digraph %q {\n", name); err != nil {
+		return err
+	}
+	for _, n := range sortedNodes(nodes) {
+		p := nodes[n]
+		fmt.Fprintf(w, "\t%q [label=%q, style=filled, fillcolor=%q];\n", n, p.String(), nodeColor(p))
+	}
+	for _, e := range edges {
+		fmt.Fprintf(w, "\t%q -> %q [label=%q];\n", e.From, e.To, e.Kind)
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLDoc struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+func writeGraphML(w io.Writer, name string, nodes map[string]*types.Package, edges []Edge) error {
+	doc := graphMLDoc{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{"version", "node", "version", "string"},
+			{"dirty", "node", "dirty", "boolean"},
+			{"failed", "node", "failed", "boolean"},
+			{"color", "node", "color", "string"},
+			{"kind", "edge", "kind", "string"},
+		},
+	}
+	doc.Graph.ID = name
+	doc.Graph.EdgeDefault = "directed"
+
+	for _, n := range sortedNodes(nodes) {
+		p := nodes[n]
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID: n,
+			Data: []graphMLData{
+				{"version", p.Version},
+				{"dirty", fmt.Sprint(p.Dirty)},
+				{"failed", fmt.Sprint(p.Failed)},
+				{"color", nodeColor(p)},
+			},
+		})
+	}
+	for _, e := range edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Source: e.From,
+			Target: e.To,
+			Data:   []graphMLData{{"kind", string(e.Kind)}},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
+
+func sortedNodes(nodes map[string]*types.Package) []string {
+	out := make([]string, 0, len(nodes))
+	for n := range nodes {
+		out = append(out, n)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func sortEdges(edges []Edge) {
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		if edges[i].To != edges[j].To {
+			return edges[i].To < edges[j].To
+		}
+		return edges[i].Kind < edges[j].Kind
+	})
+}
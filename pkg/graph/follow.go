@@ -0,0 +1,91 @@
+package graph
+
+import (
+	"time"
+)
+
+// maxFollowBackoff caps how far the poll interval is stretched while
+// the remote is returning errors.
+const maxFollowBackoff = 16
+
+// Follow polls the tracked branch of the remote and syncs the graphs
+// to it whenever it moves.  Errors cause the poll interval to back
+// off exponentially until a poll succeeds.  Follow returns
+// immediately if no branch is tracked, and otherwise runs until
+// StopFollowing is called.
+func (m *Manager) Follow() {
+	if m.trackBranch == "" {
+		return
+	}
+
+	m.followMutex.Lock()
+	select {
+	case <-m.stopFollow:
+		m.followMutex.Unlock()
+		return
+	default:
+	}
+	done := make(chan struct{})
+	m.followDone = done
+	m.followMutex.Unlock()
+	defer close(done)
+
+	m.l.Info("Following remote branch", "branch", m.trackBranch, "interval", m.trackInterval)
+
+	wait := m.trackInterval
+	for {
+		select {
+		case <-m.stopFollow:
+			m.l.Debug("Follower shutting down")
+			return
+		case <-time.After(wait):
+		}
+
+		if err := m.followOnce(); err != nil {
+			if wait < m.trackInterval*maxFollowBackoff {
+				wait *= 2
+			}
+			m.l.Warn("Error following remote branch", "branch", m.trackBranch, "error", err, "retry", wait)
+			continue
+		}
+		wait = m.trackInterval
+	}
+}
+
+// StopFollowing stops the follower and waits for any sync that it
+// has in progress to finish.  It is safe to call more than once, and
+// a follower that has not started yet will not start.
+func (m *Manager) StopFollowing() {
+	m.stopOnce.Do(func() { close(m.stopFollow) })
+
+	m.followMutex.Lock()
+	done := m.followDone
+	m.followMutex.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+// followOnce fetches the remote and syncs to the head of the tracked
+// branch if it has moved.
+func (m *Manager) followOnce() error {
+	if err := m.UpdateCheckout(); err != nil {
+		return err
+	}
+	head, err := m.cm.RemoteHead(m.trackBranch)
+	if err != nil {
+		return err
+	}
+	if head == m.Rev() {
+		m.l.Trace("Tracked branch has not moved", "rev", head)
+		return nil
+	}
+
+	m.l.Info("Tracked branch moved", "from", m.Rev(), "to", head)
+	if _, err := m.SyncTo(head); err != nil {
+		return err
+	}
+	m.Clean()
+	m.l.Info("Published revision", "rev", head)
+	return nil
+}
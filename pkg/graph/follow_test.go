@@ -0,0 +1,66 @@
+package graph
+
+import (
+	"testing"
+	"time"
+)
+
+// blockingCheckout holds every fetch until it is released.
+type blockingCheckout struct {
+	fakeCheckout
+	fetching chan struct{}
+	release  chan struct{}
+}
+
+func (b *blockingCheckout) Fetch() error {
+	b.fetching <- struct{}{}
+	<-b.release
+	return nil
+}
+
+func TestStopFollowingWaits(t *testing.T) {
+	m := NewManager(WithTracking("master", time.Millisecond))
+	cm := &blockingCheckout{fetching: make(chan struct{}), release: make(chan struct{})}
+	m.cm = cm
+
+	go m.Follow()
+	<-cm.fetching
+
+	stopped := make(chan struct{})
+	go func() {
+		m.StopFollowing()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("StopFollowing returned during a poll")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(cm.release)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("StopFollowing did not return after the poll finished")
+	}
+
+	// A second stop is harmless.
+	m.StopFollowing()
+}
+
+func TestStopFollowingBeforeStart(t *testing.T) {
+	m := NewManager(WithTracking("master", time.Millisecond))
+	m.StopFollowing()
+
+	done := make(chan struct{})
+	go func() {
+		m.Follow()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Follow started after StopFollowing")
+	}
+}
@@ -1,11 +1,15 @@
 package graph
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/the-maldridge/nbuild/pkg/repo"
 	"github.com/the-maldridge/nbuild/pkg/types"
 )
 
@@ -17,12 +21,30 @@ func (m *Manager) HTTPEntry() chi.Router {
 	r.Get("/atom/{host}/{target}", m.httpDumpAtom)
 	r.Get("/pkgs/{host}/{target}/{pkg}", m.httpDumpPkg)
 	r.Get("/dirty/{host}/{target}", m.httpDumpDirty)
+	r.Get("/unbuildable/{host}/{target}", m.httpDumpUnbuildable)
 	r.Get("/dispatchable", m.httpDumpDispatch)
+	r.Get("/rdeps/{host}/{target}/{pkg}", m.httpDumpRevDeps)
+	r.Get("/cycles/{host}/{target}", m.httpDumpCycles)
+	r.Get("/explain/{host}/{target}/{pkg}", m.httpExplainPkg)
+	r.Get("/export/{host}/{target}/{format}", m.httpExportGraph)
+	r.Get("/diff/{host}/{target}", m.httpDiffGraph)
+	r.Get("/snapshots/{host}/{target}", m.httpListSnapshots)
+	r.Get("/snapshots/{host}/{target}/{rev}/pkgs/{pkg}", m.httpDumpSnapshotPkg)
+	r.Get("/snapshots/{host}/{target}/{rev}/dirty", m.httpDumpSnapshotDirty)
+	r.Get("/plan/{host}/{target}", m.httpDumpPlan)
+	r.Get("/cache", m.httpDumpCacheStats)
+	r.Get("/repodata", m.httpDumpRepoDataStats)
+	r.Get("/index/{target}", m.httpQueryIndex)
+	r.Get("/staged/{target}", m.httpDumpStaged)
+	r.Get("/mirrors", m.httpDumpMirrors)
+	r.Get("/events", m.httpEvents)
+	r.Get("/obsolete/{target}", m.httpDumpObsolete)
 
 	r.Post("/pkgs/{host}/{target}/{pkg}/fail", m.httpFailPkg)
 	r.Post("/pkgs/{host}/{target}/{pkg}/unfail", m.httpUnfailPkg)
 	r.Post("/clean/{target}", m.httpCleanTarget)
 	r.Post("/syncto/{sha}", m.httpSyncToRev)
+	r.Post("/obsolete/{target}/remove", m.httpRemoveObsolete)
 
 	return r
 }
@@ -34,13 +56,10 @@ func (m *Manager) httpDumpAtom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	graph.PkgsMutex.Lock()
-	defer graph.PkgsMutex.Unlock()
-
 	enc := json.NewEncoder(w)
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
-	enc.Encode(graph.atom)
+	enc.Encode(graph.GetAtom())
 }
 
 func (m *Manager) httpDumpPkg(w http.ResponseWriter, r *http.Request) {
@@ -50,9 +69,7 @@ func (m *Manager) httpDumpPkg(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	graph.PkgsMutex.Lock()
-	defer graph.PkgsMutex.Unlock()
-	pkg, ok := graph.atom.Pkgs[chi.URLParam(r, "pkg")]
+	pkg, ok := graph.GetAtom().Pkgs[chi.URLParam(r, "pkg")]
 	if !ok {
 		w.WriteHeader(http.StatusNotFound)
 		return
@@ -73,12 +90,41 @@ func (m *Manager) httpDumpDirty(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	atom := graph.GetAtom()
 	out := struct {
 		Rev  string
 		Pkgs []*types.Package
 	}{
-		Rev:  graph.atom.Rev,
-		Pkgs: m.GetDirty(spec),
+		Rev:  atom.Rev,
+		Pkgs: dirtyPkgs(atom),
+	}
+
+	enc := json.NewEncoder(w)
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(out)
+}
+
+func (m *Manager) httpDumpUnbuildable(w http.ResponseWriter, r *http.Request) {
+	spec := types.NewSpecTuple(chi.URLParam(r, "host"), chi.URLParam(r, "target"))
+	graph, ok := m.graphs[spec.String()]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	atom := graph.GetAtom()
+	pkgs := make(map[string]string)
+	for _, p := range unbuildablePkgs(atom) {
+		pkgs[p.Name] = p.BuildableReason
+	}
+
+	out := struct {
+		Rev  string
+		Pkgs map[string]string
+	}{
+		Rev:  atom.Rev,
+		Pkgs: pkgs,
 	}
 
 	enc := json.NewEncoder(w)
@@ -88,33 +134,68 @@ func (m *Manager) httpDumpDirty(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m *Manager) httpDumpDispatch(w http.ResponseWriter, r *http.Request) {
+	// A single finder is used so that the packages and their
+	// scores come from the same snapshot of each graph.
+	finder := m.newFinder()
+	dispatchable := dispatchNames(finder.ImmediatelyDispatchable())
+
 	// Its necessary to re-shape what we get from the API due to
 	// the limitations of the JSON format.  Specifically the map
 	// keys MUST be strings.
-	dispatchable := make(map[string][]string)
-	for spec, list := range m.GetDispatchable() {
-		dispatch := make(map[string]struct{}, len(list))
-		// dedup the list (subpkgs add the parent multiple
-		// times)
-		for _, pkg := range list {
-			dispatch[pkg.Name] = struct{}{}
-		}
-
-		ret := make([]string, len(dispatch))
-		i := 0
-		for key := range dispatch {
-			ret[i] = key
-			i++
-		}
-		dispatchable[spec.String()] = ret
+	scores := make(map[string]map[string]int)
+	for spec, pkgs := range finder.Scores() {
+		scores[spec.String()] = pkgs
 	}
 
 	out := struct {
 		Pkgs     map[string][]string
+		Scores   map[string]map[string]int
 		Revision string
 	}{
 		Pkgs:     dispatchable,
-		Revision: m.rev,
+		Scores:   scores,
+		Revision: m.Rev(),
+	}
+
+	enc := json.NewEncoder(w)
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(out)
+}
+
+func (m *Manager) httpDumpRevDeps(w http.ResponseWriter, r *http.Request) {
+	spec := types.NewSpecTuple(chi.URLParam(r, "host"), chi.URLParam(r, "target"))
+	transitive, _ := strconv.ParseBool(r.URL.Query().Get("transitive"))
+
+	rdeps, err := m.RevDeps(spec, chi.URLParam(r, "pkg"), transitive)
+	if err != nil {
+		jsonError(w, err, http.StatusNotFound)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(rdeps)
+}
+
+func (m *Manager) httpDumpCycles(w http.ResponseWriter, r *http.Request) {
+	spec := types.NewSpecTuple(chi.URLParam(r, "host"), chi.URLParam(r, "target"))
+	graph, ok := m.graphs[spec.String()]
+	if !ok {
+		jsonError(w, errors.New("spec not found"), http.StatusNotFound)
+		return
+	}
+
+	// The revision is taken from the same atom as the cycles,
+	// since the manager may have moved on during a sync.
+	atom := graph.GetAtom()
+	out := struct {
+		Rev    string
+		Cycles [][]string
+	}{
+		Rev:    atom.Rev,
+		Cycles: atom.Cycles,
 	}
 
 	enc := json.NewEncoder(w)
@@ -123,6 +204,233 @@ func (m *Manager) httpDumpDispatch(w http.ResponseWriter, r *http.Request) {
 	enc.Encode(out)
 }
 
+func (m *Manager) httpExplainPkg(w http.ResponseWriter, r *http.Request) {
+	spec := types.NewSpecTuple(chi.URLParam(r, "host"), chi.URLParam(r, "target"))
+	e, err := m.Explain(spec, chi.URLParam(r, "pkg"))
+	if err != nil {
+		jsonError(w, err, http.StatusNotFound)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(e)
+}
+
+func (m *Manager) httpExportGraph(w http.ResponseWriter, r *http.Request) {
+	spec := types.NewSpecTuple(chi.URLParam(r, "host"), chi.URLParam(r, "target"))
+	format := chi.URLParam(r, "format")
+	root := r.URL.Query().Get("pkg")
+	depth := 1
+	if d := r.URL.Query().Get("depth"); d != "" {
+		var err error
+		depth, err = strconv.Atoi(d)
+		if err != nil {
+			jsonError(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch format {
+	case FormatDOT:
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+	case FormatGraphML:
+		w.Header().Set("Content-Type", "application/xml")
+	default:
+		jsonError(w, errors.New("unknown export format"), http.StatusBadRequest)
+		return
+	}
+
+	// Render to a buffer first so that errors can still be
+	// reported with a sensible status code.
+	buf := new(bytes.Buffer)
+	if err := m.Export(buf, spec, format, root, depth); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		jsonError(w, err, http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	buf.WriteTo(w)
+}
+
+func (m *Manager) httpDiffGraph(w http.ResponseWriter, r *http.Request) {
+	spec := types.NewSpecTuple(chi.URLParam(r, "host"), chi.URLParam(r, "target"))
+	d, err := m.Diff(spec, r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if err != nil {
+		jsonError(w, err, http.StatusNotFound)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(d)
+}
+
+func (m *Manager) httpListSnapshots(w http.ResponseWriter, r *http.Request) {
+	spec := types.NewSpecTuple(chi.URLParam(r, "host"), chi.URLParam(r, "target"))
+	snaps, err := m.Snapshots(spec)
+	if err != nil {
+		jsonError(w, err, http.StatusNotFound)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(snaps)
+}
+
+func (m *Manager) httpDumpSnapshotPkg(w http.ResponseWriter, r *http.Request) {
+	spec := types.NewSpecTuple(chi.URLParam(r, "host"), chi.URLParam(r, "target"))
+	atom, err := m.SnapshotAt(spec, chi.URLParam(r, "rev"))
+	if err != nil {
+		jsonError(w, err, http.StatusNotFound)
+		return
+	}
+	pkg, ok := atom.Pkgs[chi.URLParam(r, "pkg")]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(pkg)
+}
+
+func (m *Manager) httpDumpSnapshotDirty(w http.ResponseWriter, r *http.Request) {
+	spec := types.NewSpecTuple(chi.URLParam(r, "host"), chi.URLParam(r, "target"))
+	atom, err := m.SnapshotAt(spec, chi.URLParam(r, "rev"))
+	if err != nil {
+		jsonError(w, err, http.StatusNotFound)
+		return
+	}
+
+	out := struct {
+		Rev  string
+		Pkgs []*types.Package
+	}{
+		Rev:  atom.Rev,
+		Pkgs: dirtyPkgs(atom),
+	}
+
+	enc := json.NewEncoder(w)
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(out)
+}
+
+func (m *Manager) httpDumpPlan(w http.ResponseWriter, r *http.Request) {
+	spec := types.NewSpecTuple(chi.URLParam(r, "host"), chi.URLParam(r, "target"))
+	plan, err := m.Plan(spec)
+	if err != nil {
+		jsonError(w, err, http.StatusNotFound)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(plan)
+}
+
+func (m *Manager) httpDumpCacheStats(w http.ResponseWriter, r *http.Request) {
+	enc := json.NewEncoder(w)
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(m.CacheStats())
+}
+
+func (m *Manager) httpDumpRepoDataStats(w http.ResponseWriter, r *http.Request) {
+	enc := json.NewEncoder(w)
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(m.idx.Stats())
+}
+
+func (m *Manager) httpQueryIndex(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+	q := repo.Query{
+		Repo:          v.Get("repo"),
+		Name:          v.Get("name"),
+		Provides:      v.Get("provides"),
+		ShlibProvides: v.Get("shlib-provides"),
+		ShlibRequires: v.Get("shlib-requires"),
+		RunDepends:    v.Get("run-depends"),
+		Replaces:      v.Get("replaces"),
+		SHA256:        v.Get("sha256"),
+		BuildDate:     v.Get("build-date"),
+	}
+	if size := v.Get("filename-size"); size != "" {
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			jsonError(w, err, http.StatusBadRequest)
+			return
+		}
+		q.FilenameSize = n
+	}
+	entries, err := m.idx.Query(chi.URLParam(r, "target"), q)
+	if err != nil {
+		jsonError(w, err, http.StatusNotFound)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(entries)
+}
+
+func (m *Manager) httpDumpMirrors(w http.ResponseWriter, r *http.Request) {
+	enc := json.NewEncoder(w)
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(m.idx.Mirrors())
+}
+
+func (m *Manager) httpDumpStaged(w http.ResponseWriter, r *http.Request) {
+	staged, err := m.idx.Staged(chi.URLParam(r, "target"))
+	if err != nil {
+		jsonError(w, err, http.StatusNotFound)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(staged)
+}
+
+func (m *Manager) httpDumpObsolete(w http.ResponseWriter, r *http.Request) {
+	report, err := m.Obsolete(chi.URLParam(r, "target"))
+	if err != nil {
+		jsonError(w, err, http.StatusNotFound)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(report)
+}
+
+func (m *Manager) httpRemoveObsolete(w http.ResponseWriter, r *http.Request) {
+	downgrades, _ := strconv.ParseBool(r.URL.Query().Get("downgrades"))
+	report, err := m.RemoveObsolete(chi.URLParam(r, "target"), downgrades)
+	if err != nil {
+		jsonError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(report)
+}
+
 func (m *Manager) httpFailPkg(w http.ResponseWriter, r *http.Request) {
 	graph, ok := m.graphs[types.SpecTuple{chi.URLParam(r, "host"), chi.URLParam(r, "target")}.String()]
 	if !ok {
@@ -134,6 +442,7 @@ func (m *Manager) httpFailPkg(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	m.checkDispatchable()
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -149,6 +458,7 @@ func (m *Manager) httpUnfailPkg(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	m.checkDispatchable()
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -157,7 +467,7 @@ func (m *Manager) httpCleanTarget(w http.ResponseWriter, r *http.Request) {
 	tgt := chi.URLParam(r, "target")
 
 	enc := json.NewEncoder(w)
-	if err := m.idx.ReloadArch(tgt); err != nil {
+	if err := m.idx.ReloadArch(tgt); err == repo.ErrUnknownArch {
 		w.WriteHeader(http.StatusInternalServerError)
 		out := struct {
 			Error string
@@ -166,13 +476,24 @@ func (m *Manager) httpCleanTarget(w http.ResponseWriter, r *http.Request) {
 		}
 		enc.Encode(out)
 		return
+	} else if err != nil {
+		// The repodata that was loaded before is still
+		// usable until it becomes too stale to clean from.
+		m.l.Warn("Error reloading index", "target", tgt, "error", err)
 	}
 
+	var cleanErr error
 	for spec, graph := range m.graphs {
 		if types.SpecTupleFromString(spec).Target != tgt {
 			continue
 		}
-		m.CleanSpec(types.SpecTupleFromString(spec), graph)
+		if err := m.CleanSpec(types.SpecTupleFromString(spec), graph); err != nil {
+			cleanErr = err
+		}
+	}
+	m.checkDispatchable()
+	if cleanErr != nil {
+		jsonError(w, cleanErr, http.StatusServiceUnavailable)
 	}
 }
 
@@ -182,13 +503,27 @@ func (m *Manager) httpSyncToRev(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := m.SyncTo(chi.URLParam(r, "sha")); err != nil {
+	rev := chi.URLParam(r, "sha")
+	mode, err := m.SyncTo(rev)
+	if err != nil {
 		jsonError(w, err, http.StatusInternalServerError)
 		return
 	}
 
 	m.Clean()
-	w.WriteHeader(http.StatusNoContent)
+
+	out := struct {
+		Rev  string
+		Mode string
+	}{
+		Rev:  rev,
+		Mode: mode,
+	}
+
+	enc := json.NewEncoder(w)
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(out)
 }
 
 func jsonError(w http.ResponseWriter, err error, code int) {
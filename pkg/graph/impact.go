@@ -0,0 +1,171 @@
+package graph
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/the-maldridge/nbuild/pkg/types"
+)
+
+// Scopes describe how far the effect of a changed path reaches.
+const (
+	// ImpactIgnore paths have no effect on the graph.
+	ImpactIgnore = "ignore"
+
+	// ImpactPackage paths affect the single package under
+	// srcpkgs that they belong to.
+	ImpactPackage = "package"
+
+	// ImpactBuildStyle paths affect every package that uses the
+	// build style named by the file.
+	ImpactBuildStyle = "build-style"
+
+	// ImpactBuildHelper paths affect every package that uses the
+	// build helper named by the file.
+	ImpactBuildHelper = "build-helper"
+
+	// ImpactFull paths may affect any package, and require a
+	// full import and a reload of the virtual packages.
+	ImpactFull = "full"
+
+	// ImpactCross paths may affect any package of a cross spec,
+	// and require a full import if any cross spec is configured.
+	ImpactCross = "cross"
+)
+
+// Modes that a sync may be performed in.
+const (
+	// SyncIncremental imports only the packages affected by the
+	// changes between two revisions.
+	SyncIncremental = "incremental"
+
+	// SyncRewind moves back to an ancestor of the current
+	// revision, and reimports everything.
+	SyncRewind = "rewind"
+
+	// SyncFull reimports everything because the revisions do not
+	// share history, such as after a force push.
+	SyncFull = "full"
+)
+
+// defaultImpactRules are used when no rules are configured.  Rules
+// are checked in order and the first match wins, so more specific
+// rules must come first.
+var defaultImpactRules = []types.ImpactRule{
+	{Pattern: "srcpkgs", Scope: ImpactPackage},
+	{Pattern: "common/build-style", Scope: ImpactBuildStyle},
+	{Pattern: "common/environment/build-style", Scope: ImpactBuildStyle},
+	{Pattern: "common/build-helper", Scope: ImpactBuildHelper},
+	{Pattern: "common/cross-profiles", Scope: ImpactCross},
+	{Pattern: "common/environment", Scope: ImpactFull},
+	{Pattern: "common/xbps-src", Scope: ImpactFull},
+	{Pattern: "common/shlibs", Scope: ImpactFull},
+	{Pattern: "etc/defaults.virtual", Scope: ImpactFull},
+	{Pattern: "etc/defaults.conf", Scope: ImpactFull},
+	{Pattern: "xbps-src", Scope: ImpactFull},
+}
+
+// classify returns the scope of a single path relative to the root of
+// the checkout.
+func (m *Manager) classify(rel string) string {
+	for _, r := range m.impactRules {
+		if r.Matches(rel) {
+			return r.Scope
+		}
+	}
+	return ImpactIgnore
+}
+
+// planImport works out what needs to be reimported for a set of
+// changed paths.
+func (m *Manager) planImport(changed []string) importPlan {
+	plan := importPlan{}
+	pkgs := make(map[string]struct{})
+	styles := make(map[string]struct{})
+	helpers := make(map[string]struct{})
+
+	for _, p := range changed {
+		rel, err := filepath.Rel(m.basepath, p)
+		if err != nil {
+			rel = p
+		}
+		rel = filepath.ToSlash(rel)
+
+		scope := m.classify(rel)
+		m.l.Trace("Classified path", "path", rel, "scope", scope)
+		switch scope {
+		case ImpactPackage:
+			parts := strings.SplitN(rel, "/", 3)
+			if len(parts) < 2 {
+				continue
+			}
+			pkgs[parts[1]] = struct{}{}
+		case ImpactBuildStyle:
+			styles[strings.TrimSuffix(path.Base(rel), ".sh")] = struct{}{}
+		case ImpactBuildHelper:
+			helpers[strings.TrimSuffix(path.Base(rel), ".sh")] = struct{}{}
+		case ImpactFull:
+			m.l.Info("Infrastructure change requires full import", "path", rel)
+			plan.full = true
+		case ImpactCross:
+			if m.hasCross() {
+				m.l.Info("Cross profile change requires full import", "path", rel)
+				plan.full = true
+			}
+		}
+	}
+
+	if plan.full {
+		return plan
+	}
+
+	if len(styles) > 0 || len(helpers) > 0 {
+		for _, name := range m.packagesUsing(styles, helpers) {
+			pkgs[name] = struct{}{}
+		}
+	}
+
+	for name := range pkgs {
+		plan.paths = append(plan.paths, filepath.Join(m.basepath, "srcpkgs", name))
+	}
+	sort.Strings(plan.paths)
+	return plan
+}
+
+// hasCross reports whether any of the specs is a cross spec.
+func (m *Manager) hasCross() bool {
+	for _, spec := range m.specs {
+		if !spec.Native() {
+			return true
+		}
+	}
+	return false
+}
+
+// packagesUsing scans the templates in the checkout for packages that
+// use any of the listed build styles or build helpers.
+func (m *Manager) packagesUsing(styles, helpers map[string]struct{}) []string {
+	templates, _ := filepath.Glob(filepath.Join(m.basepath, "srcpkgs", "*", "template"))
+	out := []string{}
+	for _, t := range templates {
+		vars := templateVars(t, "build_style", "build_helper")
+		used := false
+		for _, s := range strings.Fields(vars["build_style"]) {
+			if _, ok := styles[s]; ok {
+				used = true
+			}
+		}
+		for _, h := range strings.Fields(vars["build_helper"]) {
+			if _, ok := helpers[h]; ok {
+				used = true
+			}
+		}
+		if used {
+			out = append(out, filepath.Base(filepath.Dir(t)))
+		}
+	}
+	m.l.Debug("Packages affected by infrastructure change", "count", len(out))
+	return out
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -13,35 +14,99 @@ import (
 	"github.com/hashicorp/go-hclog"
 
 	"github.com/the-maldridge/nbuild/pkg/types"
+	"github.com/the-maldridge/nbuild/pkg/version"
 )
 
 // New returns a new blank tree with the logger configured
 func New(l hclog.Logger, spec types.SpecTuple) *PkgGraph {
 	x := PkgGraph{
 		l:           l.Named(spec.String()),
+		spec:        spec,
 		basePath:    "void-packages",
 		parallelism: 10,
-		PkgsMutex:   new(sync.Mutex),
-		AuxMutex:    new(sync.Mutex),
+		writeMu:     new(sync.Mutex),
+		cache:       newDumpCache(l, nil),
+	}
+	x.publish(&graphState{
 		atom: types.Atom{
 			Pkgs:    make(map[string]*types.Package),
 			Virtual: make(map[string]string),
 			Bad:     make(map[string]string),
+			Owners:  make(map[string]string),
 			Spec:    spec,
 		},
-	}
+		rdeps: make(revIndex),
+	})
 	return &x
 }
 
 // ImportAll tries to read every srcpkg from disk and then converge the
 // graph.
 func (t *PkgGraph) ImportAll() error {
-	paths, _ := filepath.Glob(filepath.Join(t.basePath, "srcpkgs", "*"))
-	return t.importFromPaths(paths)
+	r := t.importFromPaths(t.allPaths())
+	t.update(func(s *graphState) {
+		t.applyImport(s, r)
+		t.collectGarbage(s)
+	})
+	return nil
 }
 
 // ImportChanged looks at a range of paths and imports just those.
-func (t *PkgGraph) ImportChanged(paths []string) error {
+func (t *PkgGraph) ImportChanged(changed []string) error {
+	r := t.importFromPaths(changedPaths(changed))
+	t.update(func(s *graphState) {
+		t.applyImport(s, r)
+	})
+	return nil
+}
+
+// Sync imports the changed paths, or every package if full is set,
+// and records the revision and sync mode.  Readers see the result of
+// the whole sync at once.
+func (t *PkgGraph) Sync(changed []string, full bool, rev, mode string) error {
+	var virtual map[string]string
+	var r *importResult
+	if full {
+		var err error
+		virtual, err = t.readVirtual()
+		if err != nil {
+			t.l.Warn("Error loading virtual packages", "error", err)
+		}
+		r = t.importFromPaths(t.allPaths())
+	} else {
+		r = t.importFromPaths(changedPaths(changed))
+	}
+
+	t.update(func(s *graphState) {
+		// The published atom is never modified, so it can be
+		// kept as is for diffing.
+		before := t.load().atom
+		t.applyImport(s, r)
+		if full {
+			t.collectGarbage(s)
+			if virtual != nil {
+				s.atom.Virtual = virtual
+			}
+		}
+		s.atom.Cycles = t.findCycles(s.atom)
+		s.atom.Rev = rev
+		s.atom.SyncMode = mode
+		s.prev = &before
+	})
+	return nil
+}
+
+// allPaths lists the directory of every srcpkg in the checkout.
+func (t *PkgGraph) allPaths() []string {
+	paths, _ := filepath.Glob(filepath.Join(t.basePath, "srcpkgs", "*"))
+	return paths
+}
+
+// changedPaths rewrites changed template files to the directory of
+// the package they belong to.
+func changedPaths(changed []string) []string {
+	paths := make([]string, len(changed))
+	copy(paths, changed)
 	for i := range paths {
 		if filepath.Base(paths[i]) == "template" {
 			// Something in a template changed, we need to
@@ -49,16 +114,23 @@ func (t *PkgGraph) ImportChanged(paths []string) error {
 			paths[i] = filepath.Dir(paths[i])
 		}
 	}
-	return t.importFromPaths(paths)
+	return paths
 }
 
 // importFromPaths is a shared function for both import codepaths and
-// handles the import of packages based on a set of changed paths.
-func (t *PkgGraph) importFromPaths(paths []string) error {
-	pkgCount := 0
+// loads the packages affected by a set of changed paths.  The graph
+// is not modified, the result is applied with applyImport.
+func (t *PkgGraph) importFromPaths(paths []string) *importResult {
+	cur := t.load()
+	r := &importResult{
+		mu:   new(sync.Mutex),
+		bad:  make(map[string]string),
+		errs: make(map[string]string),
+	}
 
 	loadCh := make(chan string, 200)
 	wg := new(sync.WaitGroup)
+	common := t.commonHash()
 
 	for i := 0; i < t.parallelism; i++ {
 		wg.Add(1)
@@ -71,28 +143,64 @@ func (t *PkgGraph) importFromPaths(paths []string) error {
 					return
 				}
 				t.l.Debug("Loading Package", "package", p)
-				spkg, err := t.loadFromDisk(p)
+				spkg, err := t.loadFromDisk(p, common)
+				r.mu.Lock()
 				if err != nil {
 					t.l.Warn("Error loading package", "package", p, "error", err)
-					continue
+					r.errs[p] = err.Error()
+					var exitError *exec.ExitError
+					if errors.As(err, &exitError) {
+						r.bad[p] = string(exitError.Stderr)
+					}
+				} else {
+					r.pkgs = append(r.pkgs, spkg)
 				}
-				t.PkgsMutex.Lock()
-				t.atom.Pkgs[p] = spkg
-
-				pkgCount++
-				t.PkgsMutex.Unlock()
+				r.mu.Unlock()
 			}
 		}(i)
 	}
 
+	queued := make(map[string]struct{})
+	queue := func(pkgname string) {
+		if _, ok := queued[pkgname]; ok {
+			return
+		}
+		queued[pkgname] = struct{}{}
+		loadCh <- pkgname
+	}
+
 	for _, p := range paths {
+		pkgname := filepath.Base(p)
 		pinfo, err := os.Lstat(p)
 		if err != nil {
 			t.l.Warn("Error with path", "error", err, "path", p)
-			t.PkgsMutex.Lock()
-			pkgname := filepath.Base(p)
-			delete(t.atom.Pkgs, pkgname)
-			t.PkgsMutex.Unlock()
+			owner, isSubpkg := cur.atom.Owners[pkgname]
+			if !isSubpkg || owner == pkgname {
+				r.removed = append(r.removed, pkgname)
+			} else if t.pkgExists(owner) {
+				// The subpackage symlink went away,
+				// so the parent needs to be
+				// reconciled.
+				r.removed = append(r.removed, pkgname)
+				queue(owner)
+			}
+			continue
+		}
+
+		if pinfo.Mode()&os.ModeSymlink != 0 {
+			// Subpackages are symlinks to the directory
+			// of their parent.
+			target, err := os.Readlink(p)
+			if err != nil {
+				t.l.Warn("Error reading subpackage link", "error", err, "path", p)
+				continue
+			}
+			parent := filepath.Base(target)
+			if !t.pkgExists(parent) {
+				continue
+			}
+			t.l.Trace("Mapped subpackage to parent", "subpackage", pkgname, "parent", parent)
+			queue(parent)
 			continue
 		}
 
@@ -100,173 +208,417 @@ func (t *PkgGraph) importFromPaths(paths []string) error {
 			// We only care about the directories
 			continue
 		}
-		pkgname := filepath.Base(p)
 		if !t.pkgExists(pkgname) {
 			continue
 		}
-		loadCh <- pkgname
+		queue(pkgname)
 	}
 	close(loadCh)
 	wg.Wait()
-	t.l.Debug("Loaded packages", "count", pkgCount)
-	return nil
+	t.l.Debug("Loaded packages", "count", len(r.pkgs))
+	return r
+}
+
+// applyImport merges the result of an import into a state.
+func (t *PkgGraph) applyImport(s *graphState, r *importResult) {
+	for _, name := range r.removed {
+		if owner, ok := s.atom.Owners[name]; ok && owner != name {
+			// Only the subpackage alias goes away, the
+			// parent is reimported.
+			if cur, ok := s.atom.Pkgs[name]; ok && cur.Name != name {
+				delete(s.atom.Pkgs, name)
+			}
+			delete(s.atom.Owners, name)
+			continue
+		}
+		t.removePackage(s, name)
+	}
+	for name, stderr := range r.bad {
+		s.atom.Bad[name] = stderr
+	}
+	for name, err := range r.errs {
+		s.notify(Event{Type: EventImportError, Package: name, Error: err})
+	}
+	for _, p := range r.pkgs {
+		old, ok := s.atom.Pkgs[p.Name]
+		if ok && old.Name == p.Name {
+			t.unindexDeps(s, old)
+		}
+		if p.Dirty && (!ok || old.Name != p.Name || !old.Dirty) {
+			s.notify(Event{Type: EventDirty, Package: p.Name})
+		}
+		// A package that loads, whether from the cache or
+		// from a fresh dump, is no longer bad.
+		delete(s.atom.Bad, p.Name)
+		s.setPkg(p)
+		t.indexDeps(s, p)
+		t.setupSubpackages(s, p)
+	}
 }
 
 // LoadVirtual loads the virtual package map from the defaults file in
 // the checkout.'
 func (t *PkgGraph) LoadVirtual() error {
-	f, err := os.Open(filepath.Join(t.basePath, "etc/defaults.virtual"))
+	virtual, err := t.readVirtual()
 	if err != nil {
 		return err
 	}
+	t.update(func(s *graphState) {
+		for k, v := range virtual {
+			s.atom.Virtual[k] = v
+		}
+	})
+	return nil
+}
+
+// readVirtual parses the virtual package defaults file.
+func (t *PkgGraph) readVirtual() (map[string]string, error) {
+	f, err := os.Open(filepath.Join(t.basePath, "etc/defaults.virtual"))
+	if err != nil {
+		return nil, err
+	}
 	defer f.Close()
 	scanner := bufio.NewScanner(f)
 
-	t.AuxMutex.Lock()
+	out := make(map[string]string)
 	for scanner.Scan() {
 		l := strings.TrimSpace(scanner.Text())
 		if strings.HasPrefix(l, "#") || l == "" {
 			continue
 		}
 		flds := strings.Fields(l)
-		t.atom.Virtual[flds[0]] = flds[1]
+		out[flds[0]] = flds[1]
 	}
-	t.AuxMutex.Unlock()
-	return nil
+	return out, nil
 }
 
 // ResolvePackage tries to return a soure package that is referenced
-// by any of the means that are valid in xbps-src
+// by any of the means that are valid in xbps-src.  If the reference
+// carries a version constraint the package must also satisfy it.
 func (t *PkgGraph) ResolvePackage(name string) (*types.Package, error) {
-	pp, ok := t.atom.Pkgs[name]
+	return t.resolvePackage(t.load().atom, name)
+}
+
+func (t *PkgGraph) resolvePackage(a types.Atom, name string) (*types.Package, error) {
+	pp, ok := a.Pkgs[name]
 	if ok {
 		t.l.Trace("Already loaded package", "package", name)
 		return pp, nil
 	}
 
 	if strings.HasPrefix(name, "virtual?") {
-		name = t.atom.Virtual[strings.ReplaceAll(name, "virtual?", "")]
-		return t.ResolvePackage(name)
+		name = a.Virtual[strings.ReplaceAll(name, "virtual?", "")]
+		return t.resolvePackage(a, name)
 	}
 
-	if strings.ContainsAny(name, "<>=") {
-		n, err := t.getpkgdepname(name)
-		if err != nil {
-			t.l.Trace("Error getpkgdepname", "error", err)
-			return nil, err
-		}
-		return t.ResolvePackage(n)
+	n := version.PatternName(name)
+	pp, ok = a.Pkgs[n]
+	if !ok {
+		t.l.Trace("Unable to resolve package", "package", name)
+		return nil, errors.New("pkg not found")
 	}
-
-	// last resort
-	n, err := t.getpkgname(name)
-	if err != nil {
-		t.l.Trace("Error getpkgname", "error", err)
-		return nil, err
+	if !version.Satisfies(n, pp.Version, name) {
+		t.l.Trace("Package does not satisfy constraint", "package", name, "version", pp.Version)
+		return nil, errors.New("pkg version does not satisfy constraint")
 	}
-	return t.ResolvePackage(n)
+	return pp, nil
 }
 
-// GetDirty returns a list of packages that have the dirty flag set.
+// GetDirty returns a list of packages that have the dirty flag set
+// and that can be built for this spec.
 func (t *PkgGraph) GetDirty() []*types.Package {
+	out := dirtyPkgs(t.load().atom)
+	t.l.Debug("There are dirty packages", "count", len(out))
+	return out
+}
+
+// dirtyPkgs returns the packages of an atom that are dirty and can
+// be built.
+func dirtyPkgs(a types.Atom) []*types.Package {
 	out := []*types.Package{}
+	for _, pkg := range a.Pkgs {
+		if pkg.Dirty && !pkg.Failed && pkg.Buildable {
+			out = append(out, pkg)
+		}
+	}
+	return out
+}
 
-	t.PkgsMutex.Lock()
-	for _, pkg := range t.atom.Pkgs {
-		if pkg.Dirty && !pkg.Failed {
+// GetUnbuildable returns the source packages that cannot be built for
+// this spec.
+func (t *PkgGraph) GetUnbuildable() []*types.Package {
+	return unbuildablePkgs(t.load().atom)
+}
+
+// unbuildablePkgs returns the source packages of an atom that cannot
+// be built.
+func unbuildablePkgs(a types.Atom) []*types.Package {
+	out := []*types.Package{}
+	for name, pkg := range a.Pkgs {
+		if name == pkg.Name && !pkg.Buildable {
 			out = append(out, pkg)
 		}
 	}
-	t.PkgsMutex.Unlock()
-	t.l.Debug("There are dirty packages", "count", len(out))
 	return out
 }
 
-// GetAtom returns the current state of the atom.
+// setBuildable computes whether a package can be built for the spec
+// of this graph.  A package is not buildable if it is broken, if it
+// sets nocross and the spec is a cross spec, if its archs exclude the
+// target arch, or if it is restricted.  The first of these that
+// applies is recorded as the reason.
+func (t *PkgGraph) setBuildable(p *types.Package) {
+	spec := t.spec
+	p.Buildable = false
+	switch {
+	case p.Broken != "":
+		p.BuildableReason = "broken: " + p.Broken
+	case !spec.Native() && p.NoCross != "":
+		p.BuildableReason = "nocross: " + p.NoCross
+	case !archAllowed(p.Archs, spec.Target):
+		p.BuildableReason = "archs: " + strings.Join(p.Archs, " ")
+	case p.Restricted:
+		p.BuildableReason = "restricted"
+	default:
+		p.Buildable = true
+		p.BuildableReason = ""
+	}
+}
+
+// updateBuildable recomputes the buildable state of every package in
+// a state.  This is used after loading a graph from storage.
+func (t *PkgGraph) updateBuildable(s *graphState) {
+	for name, p := range s.atom.Pkgs {
+		if name == p.Name {
+			s.mutatePkg(name, t.setBuildable)
+		}
+	}
+}
+
+// archAllowed applies the rules of the archs variable in a template.
+// Each entry is a glob, entries prefixed with a ~ exclude matching
+// archs, and if any positive entries are present at least one of
+// them must match.
+func archAllowed(archs []string, arch string) bool {
+	positive := false
+	matched := false
+	for _, a := range archs {
+		if strings.HasPrefix(a, "~") {
+			if m, _ := path.Match(a[1:], arch); m {
+				return false
+			}
+			continue
+		}
+		positive = true
+		if m, _ := path.Match(a, arch); m {
+			matched = true
+		}
+	}
+	return !positive || matched
+}
+
+// GetAtom returns the current state of the atom.  The atom is an
+// immutable snapshot which remains consistent while the graph
+// continues to change, and it must not be modified.
 func (t *PkgGraph) GetAtom() types.Atom {
-	return t.atom
+	return t.load().atom
 }
 
 // CleanPkg clears the dirty bit on a named package.
 func (t *PkgGraph) CleanPkg(pkg string) {
-	t.PkgsMutex.Lock()
-	defer t.PkgsMutex.Unlock()
-	p, ok := t.atom.Pkgs[pkg]
-	if !ok {
-		t.l.Warn("Attempted clean on non-existant package!", "package", pkg)
+	t.CleanPkgs([]string{pkg})
+}
+
+// CleanPkgs clears the dirty bit on each of the named packages in a
+// single update.
+func (t *PkgGraph) CleanPkgs(pkgs []string) {
+	if len(pkgs) == 0 {
 		return
 	}
-	p.Dirty = false
-	t.l.Trace("Cleaned package", "package", pkg)
+	t.SetRepoStates(nil, nil, pkgs)
 }
 
 // FailPkg sets the failed bit on a named package.
 func (t *PkgGraph) FailPkg(pkg string) error {
-	t.PkgsMutex.Lock()
-	defer t.PkgsMutex.Unlock()
-	p, ok := t.atom.Pkgs[pkg]
-	if !ok {
-		t.l.Warn("Attempted setting fail on non-existant package!", "package", pkg)
-		return errors.New("pkg not found")
-	}
-	p.Failed = true
-	t.l.Trace("Failed package", "package", pkg)
-	return nil
+	return t.setFailed(pkg, true)
 }
 
 // UnfailPkg clears the failed bit on a named package.
 func (t *PkgGraph) UnfailPkg(pkg string) error {
-	t.PkgsMutex.Lock()
-	defer t.PkgsMutex.Unlock()
-	p, ok := t.atom.Pkgs[pkg]
-	if !ok {
-		t.l.Warn("Attempted clearing fail on non-existant package!", "package", pkg)
-		return errors.New("pkg not found")
-	}
-	p.Failed = false
-	t.l.Trace("Unfailed package", "package", pkg)
-	return nil
+	return t.setFailed(pkg, false)
 }
 
-// SetupAllSubpackages is SetupSubpackages looped over all packages.
-func (t *PkgGraph) SetupAllSubpackages() {
-	for _, p := range t.atom.Pkgs {
-		t.SetupSubpackages(p)
+func (t *PkgGraph) setFailed(pkg string, failed bool) error {
+	var err error
+	t.update(func(s *graphState) {
+		p, ok := s.atom.Pkgs[pkg]
+		if !ok {
+			t.l.Warn("Attempted changing fail on non-existant package!", "package", pkg, "failed", failed)
+			err = errors.New("pkg not found")
+			return
+		}
+		if p.Failed == failed {
+			return
+		}
+		s.mutatePkg(pkg, func(p *types.Package) { p.Failed = failed })
+		e := Event{Type: EventFailed, Package: p.Name}
+		if !failed {
+			e.Type = EventUnfailed
+		}
+		s.notify(e)
+		t.l.Trace("Changed failed state of package", "package", pkg, "failed", failed)
+	})
+	return err
+}
+
+// setupAllSubpackages is setupSubpackages looped over all packages.
+func (t *PkgGraph) setupAllSubpackages(s *graphState) {
+	for name, p := range s.atom.Pkgs {
+		if name != p.Name {
+			// Only source packages own subpackages.
+			continue
+		}
+		t.setupSubpackages(s, p)
 	}
 }
 
-// SetupSubpackages takes a (normal) package and points all of its subpackages
-// to itself in the atom.
-func (t *PkgGraph) SetupSubpackages(p *types.Package) {
-	t.PkgsMutex.Lock()
-	defer t.PkgsMutex.Unlock()
+// setupSubpackages takes a (normal) package and points all of its subpackages
+// to itself in the atom.  Subpackages that the package previously
+// owned but no longer lists are removed.
+func (t *PkgGraph) setupSubpackages(s *graphState, p *types.Package) {
+	if s.atom.Owners == nil {
+		s.atom.Owners = make(map[string]string)
+	}
 	for subp := range p.Subpackages {
+		if cur, ok := s.atom.Pkgs[subp]; ok && cur.Name == subp && subp != p.Name {
+			t.l.Warn("Subpackage shadows source package", "pkg", subp, "basepkg", p.Name)
+			continue
+		}
 		t.l.Trace("Loading Subpackage", "pkg", subp, "basepkg", p.Name)
-		t.atom.Pkgs[subp] = p
+		s.atom.Pkgs[subp] = p
+		s.atom.Owners[subp] = p.Name
+	}
+
+	for subp, owner := range s.atom.Owners {
+		if owner != p.Name {
+			continue
+		}
+		if _, ok := p.Subpackages[subp]; ok {
+			continue
+		}
+		t.l.Debug("Removing stale subpackage", "pkg", subp, "basepkg", p.Name)
+		delete(s.atom.Owners, subp)
+		if cur, ok := s.atom.Pkgs[subp]; ok && cur.Name != subp {
+			delete(s.atom.Pkgs, subp)
+		}
 	}
 }
 
-func (t *PkgGraph) loadFromDisk(name string) (*types.Package, error) {
-	p := types.Package{}
+// removePackage deletes a source package and every subpackage it
+// owns from a state.
+func (t *PkgGraph) removePackage(s *graphState, name string) {
+	p, ok := s.atom.Pkgs[name]
+	if !ok {
+		return
+	}
+	if p.Name != name {
+		// This is a subpackage alias, only it goes away.
+		delete(s.atom.Pkgs, name)
+		delete(s.atom.Owners, name)
+		return
+	}
+
+	t.unindexDeps(s, p)
+	delete(s.atom.Pkgs, name)
+	for subp, owner := range s.atom.Owners {
+		if owner != name {
+			continue
+		}
+		delete(s.atom.Owners, subp)
+		if cur, ok := s.atom.Pkgs[subp]; ok && cur.Name != subp {
+			delete(s.atom.Pkgs, subp)
+		}
+	}
+	t.l.Debug("Removed package", "package", name)
+}
+
+// collectGarbage removes entries from a state that no longer have a
+// reason to exist: source packages whose directory is gone, and
+// subpackage aliases that no source package claims.
+func (t *PkgGraph) collectGarbage(s *graphState) {
+	removed := 0
+	for name, p := range s.atom.Pkgs {
+		if name == p.Name {
+			if !t.pkgExists(name) {
+				t.removePackage(s, name)
+				removed++
+			}
+			continue
+		}
+
+		owner, ok := s.atom.Pkgs[s.atom.Owners[name]]
+		if ok && owner.Name == s.atom.Owners[name] {
+			if _, listed := owner.Subpackages[name]; listed {
+				continue
+			}
+		}
+		delete(s.atom.Pkgs, name)
+		delete(s.atom.Owners, name)
+		removed++
+	}
+	for subp := range s.atom.Owners {
+		if _, ok := s.atom.Pkgs[subp]; !ok {
+			delete(s.atom.Owners, subp)
+		}
+	}
+	if removed > 0 {
+		t.l.Info("Removed orphaned packages", "count", removed)
+	}
+}
+
+// loadFromDisk obtains the dump for the named package, either from
+// the cache or by running xbps-src, and parses it into the graph.
+func (t *PkgGraph) loadFromDisk(name, common string) (*types.Package, error) {
+	sum, err := t.inputHash(name, common)
+	if err != nil {
+		t.l.Debug("Unable to hash package inputs", "package", name, "error", err)
+	}
+
+	dump, ok := t.cache.Get(t.spec, name, sum)
+	if !ok {
+		dump, err = t.dump(name)
+		if err != nil {
+			return nil, err
+		}
+		t.cache.Put(t.spec, name, sum, dump)
+	} else {
+		t.l.Trace("Using cached dump", "package", name)
+	}
+	return t.parseDump(name, dump)
+}
+
+// dump runs xbps-src to obtain the raw dbulk-dump output for a
+// package.
+func (t *PkgGraph) dump(name string) ([]byte, error) {
 	var opts []string
-	if !t.atom.Spec.Native() {
+	if !t.spec.Native() {
 		// ONLY then should we use -a
-		opts = []string{"-a", t.atom.Spec.Target}
+		opts = []string{"-a", t.spec.Target}
 	}
 	opts = append(opts, "dbulk-dump", name)
 	dump, err := exec.Command(filepath.Join(t.basePath, "xbps-src"), opts...).Output()
 	t.l.Trace("exec error", "error", err)
-	var exitError *exec.ExitError
-	if err != nil && errors.As(err, &exitError) {
-		stderr := string(exitError.Stderr)
-		t.AuxMutex.Lock()
-		t.atom.Bad[name] = stderr
-		t.AuxMutex.Unlock()
-		return nil, err
-	} else if err != nil {
+	if err != nil {
+		// Errors from xbps-src are recorded on the atom
+		// by the importer.
 		return nil, err
 	}
+	return dump, nil
+}
 
+// parseDump converts the output of dbulk-dump into a package.
+func (t *PkgGraph) parseDump(name string, dump []byte) (*types.Package, error) {
+	p := types.Package{}
 	r := bytes.NewReader(dump)
 	s := bufio.NewScanner(r)
 
@@ -324,32 +676,16 @@ func (t *PkgGraph) loadFromDisk(name string) (*types.Package, error) {
 		p.Subpackages[strings.TrimSpace(sp)] = struct{}{}
 	}
 
-	t.l.Trace("Loaded Package", "data", p)
-	t.PkgsMutex.Lock()
-	t.atom.Pkgs[name] = &p
-	t.PkgsMutex.Unlock()
-	t.SetupSubpackages(&p)
+	p.Archs = strings.Fields(tokens["archs"])
+	p.NoCross = strings.TrimSpace(tokens["nocross"])
+	p.Broken = strings.TrimSpace(tokens["broken"])
+	p.Restricted = strings.TrimSpace(tokens["restricted"]) != ""
+	t.setBuildable(&p)
 
+	t.l.Trace("Loaded Package", "data", p)
 	return &p, nil
 }
 
-func (t *PkgGraph) getpkgname(s string) (string, error) {
-	dump, err := exec.Command("xbps-uhelper", "getpkgname", s).Output()
-	if err != nil {
-		t.l.Trace("Failed to call command", "command", "xbps-uhelper getpkgname "+s)
-		return "", err
-	}
-	return string(dump), nil
-}
-
-func (t *PkgGraph) getpkgdepname(s string) (string, error) {
-	dump, err := exec.Command("xbps-uhelper", "getpkgdepname", s).Output()
-	if err != nil {
-		return "", err
-	}
-	return string(dump), nil
-}
-
 func (t *PkgGraph) pkgExists(name string) bool {
 	_, err := os.Lstat(filepath.Join(t.basePath, "srcpkgs", name, "template"))
 	return !os.IsNotExist(err)
@@ -2,12 +2,16 @@ package graph
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"path"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 
 	"github.com/the-maldridge/nbuild/pkg/dispatchable"
+	"github.com/the-maldridge/nbuild/pkg/reciever"
 	"github.com/the-maldridge/nbuild/pkg/repo"
 	"github.com/the-maldridge/nbuild/pkg/source"
 	"github.com/the-maldridge/nbuild/pkg/types"
@@ -17,16 +21,53 @@ import (
 // and returns the manager.  Graphs do not have state on return.
 func NewManager(opts ...Option) *Manager {
 	x := &Manager{
-		l:        hclog.NewNullLogger(),
-		basepath: "void-packages",
-		graphs:   make(map[string]*PkgGraph),
+		l:             hclog.NewNullLogger(),
+		basepath:      "void-packages",
+		graphs:        make(map[string]*PkgGraph),
+		impactRules:   defaultImpactRules,
+		snapMutex:     new(sync.Mutex),
+		snapshotKeep:  20,
+		syncMutex:     new(sync.Mutex),
+		trackInterval: 5 * time.Minute,
+		stopFollow:    make(chan struct{}),
+		stopOnce:      new(sync.Once),
+		followMutex:   new(sync.Mutex),
+		hookMutex:     new(sync.Mutex),
+		seenHeads:     make(map[string]struct{}),
 	}
 	for _, o := range opts {
 		o(x)
 	}
 
 	x.idx = repo.NewIndexService(x.l)
+	x.idx.SetCacheDir(x.repoDataCache)
+	x.idx.SetTrustedKeys(x.trustedKeys, x.unsignedRepos)
+	for arch, indexes := range x.indexURLs {
+		for r, mirrors := range indexes {
+			x.idx.LoadIndex(arch, r, mirrors...)
+		}
+	}
 	x.cm = source.New(x.l)
+	x.cache = newDumpCache(x.l, x.storage)
+	x.events = newEventBroker(x.l)
+	for state, policy := range x.repoPolicies {
+		if policy != PolicyBuild && policy != PolicyClean {
+			x.l.Warn("Ignoring unknown repo state policy", "state", state, "policy", policy)
+			delete(x.repoPolicies, state)
+		}
+	}
+	if len(x.webhookSecret) > 0 {
+		x.hookQueue = make(chan hookSync, hookQueueLen)
+		go x.syncHooks()
+	}
+	if x.recieverURL != "" {
+		x.reciever, _ = reciever.NewAPIClient(x.l, x.recieverURL)
+	}
+	for _, graph := range x.graphs {
+		graph.cache = x.cache
+		graph.events = x.events
+		graph.basePath = x.basepath
+	}
 	return x
 }
 
@@ -39,27 +80,31 @@ func (m *Manager) Bootstrap() error {
 		return err
 	}
 
-	var err error
-	m.rev, err = m.cm.At()
+	rev, err := m.cm.At()
 	if err != nil {
 		m.l.Error("Error retrieving git hash", "error", err)
 		return err
 	}
+	m.setRev(rev)
 	m.loadGraphs()
 
 	var wg sync.WaitGroup
 	for spec, graph := range m.graphs {
 		wg.Add(1)
 		go func(spec string, graph *PkgGraph) {
-			if graph.atom.Rev == m.rev {
+			if graph.GetAtom().Rev == rev {
+				if err := graph.LoadVirtual(); err != nil {
+					m.l.Warn("Error loading virtual packages", "spec", spec, "error", err)
+				}
+				graph.FindCycles()
 				wg.Done()
 				return
 			}
+			m.snapshot(graph.GetAtom())
 			m.l.Info("Importing graph", "spec", spec)
-			if err := graph.ImportAll(); err != nil {
+			if err := graph.Sync(nil, true, rev, SyncFull); err != nil {
 				m.l.Warn("Error importing all packages", "error", err)
 			}
-			graph.atom.Rev = m.rev
 			wg.Done()
 		}(spec, graph)
 	}
@@ -69,67 +114,144 @@ func (m *Manager) Bootstrap() error {
 	return nil
 }
 
+// Rev returns the revision that the graphs are synced to.
+func (m *Manager) Rev() string {
+	rev, _ := m.rev.Load().(string)
+	return rev
+}
+
+func (m *Manager) setRev(rev string) {
+	m.rev.Store(rev)
+}
+
 // UpdateCheckout fetches new references from git
 func (m *Manager) UpdateCheckout() error {
 	return m.cm.Fetch()
 }
 
 // SyncTo causes the graphs to all sync to a specific point in
-// history.
-func (m *Manager) SyncTo(hash string) error {
+// history.  The changes between the current and new revisions are
+// only trusted if the new revision descends from the current one,
+// otherwise history was rewritten or rewound and every package is
+// reimported.  The mode that was used is returned.
+func (m *Manager) SyncTo(hash string) (string, error) {
+	m.syncMutex.Lock()
+	defer m.syncMutex.Unlock()
+
+	mode := m.syncMode(hash)
+	m.events.publish(Event{Type: EventSyncStarted, Rev: hash, Mode: mode})
 	changed, err := m.cm.Checkout(hash)
 	if err != nil {
 		m.l.Error("Error updating checkout", "error", err)
-		return err
+		m.events.publish(Event{Type: EventSyncFinished, Rev: hash, Mode: mode, Error: err.Error()})
+		return "", err
+	}
+	plan := importPlan{full: true}
+	if mode == SyncIncremental {
+		plan = m.planImport(changed)
 	}
-	m.rev = hash
 	var wg sync.WaitGroup
 	for spec, graph := range m.graphs {
 		wg.Add(1)
 		go func(spec string, graph *PkgGraph) {
-			m.l.Debug("Syncing graph", "spec", spec)
-			if err := graph.ImportChanged(changed); err != nil {
+			m.l.Debug("Syncing graph", "spec", spec, "mode", mode, "full", plan.full)
+			before := graph.GetAtom()
+			m.snapshot(before)
+			if err := graph.Sync(plan.paths, plan.full, hash, mode); err != nil {
 				m.l.Error("Error syncing changes", "error", err, "spec", spec)
 			}
-			graph.atom.Rev = m.rev
+			m.l.Info("Graph changed", DiffAtoms(before, graph.GetAtom()).Summary()...)
 			wg.Done()
 		}(spec, graph)
 	}
 	wg.Wait()
+	m.setRev(hash)
 	m.persistGraphs()
-	m.l.Info("Synced", "changed", changed)
-	return nil
+	m.events.publish(Event{Type: EventSyncFinished, Rev: hash, Mode: mode})
+	m.checkDispatchable()
+	m.l.Info("Synced", "mode", mode, "changed", changed)
+	return mode, nil
+}
+
+// syncMode works out how the graphs must be updated to move from the
+// current revision to hash.
+func (m *Manager) syncMode(hash string) string {
+	cur := m.Rev()
+	if cur == "" {
+		return SyncFull
+	}
+	if cur == hash {
+		return SyncIncremental
+	}
+	if ok, err := m.cm.IsAncestor(cur, hash); err == nil && ok {
+		return SyncIncremental
+	} else if err != nil {
+		m.l.Warn("Unable to check ancestry, falling back to full import", "from", cur, "to", hash, "error", err)
+		return SyncFull
+	}
+	if ok, err := m.cm.IsAncestor(hash, cur); err == nil && ok {
+		m.l.Info("Rewinding to an earlier revision", "from", cur, "to", hash)
+		return SyncRewind
+	}
+	m.l.Warn("Revision does not share history with the current revision, history was rewritten", "from", cur, "to", hash)
+	return SyncFull
 }
 
 // Clean attempts to reconcile the graph with the repo index service
 // to determine what packages are present vs what is missing.
 func (m *Manager) Clean() {
 	for spec, graph := range m.graphs {
-		m.CleanSpec(types.SpecTupleFromString(spec), graph)
+		if err := m.CleanSpec(types.SpecTupleFromString(spec), graph); err != nil {
+			m.l.Warn("Unable to clean graph", "spec", spec, "error", err)
+		}
 	}
 	m.persistGraphs()
+	m.checkDispatchable()
 }
 
-// CleanSpec cleans a single spec graph.
-func (m *Manager) CleanSpec(spec types.SpecTuple, graph *PkgGraph) {
+// CleanSpec cleans a single spec graph.  The repo state of every
+// source package is recorded, and dirty packages are cleaned if the
+// policy for their repo state says so.  Cleaning is refused if the
+// repodata of the target is older than the maximum staleness.
+func (m *Manager) CleanSpec(spec types.SpecTuple, graph *PkgGraph) error {
 	m.l.Debug("Attempting to clean graph", "spec", spec)
-	for _, pkg := range graph.GetDirty() {
-		p, err := m.idx.GetPackage(spec.Target, pkg.Name)
-		if err != nil {
+	if m.maxStaleness > 0 {
+		err := m.idx.CheckFresh(spec.Target, m.maxStaleness)
+		if err == repo.ErrStale {
+			m.l.Warn("Refusing to clean from stale repodata", "spec", spec)
+			return err
+		}
+	}
+	states := make(map[string]types.RepoState)
+	publish := make(map[string]types.PublishState)
+	clean := []string{}
+	for name, pkg := range graph.GetAtom().Pkgs {
+		if name != pkg.Name {
+			continue
+		}
+		state, pub, err := m.repoState(spec.Target, pkg)
+		if err == repo.ErrUnknownArch {
+			m.l.Warn("No index is loaded for spec, unable to clean", "spec", spec)
+			return nil
+		} else if err != nil {
 			m.l.Debug("Package errors while cleaning", "spec", spec, "package", pkg, "error", err)
 			continue
 		}
-		// This looks so dumb, but is because the
-		// version field in the index includes the
-		// package name.
-		if p.Version == pkg.Name+"-"+pkg.Version {
-			m.l.Trace("Cleaning Package", "spec", spec, "package", pkg.Name, "version", pkg.Version)
-			graph.CleanPkg(pkg.Name)
+		states[name] = state
+		publish[name] = pub
+		if !pkg.Dirty {
+			continue
+		}
+		if m.repoPolicy(state) == PolicyClean {
+			m.l.Trace("Cleaning Package", "spec", spec, "package", pkg.Name, "version", pkg.Version, "state", state)
+			clean = append(clean, pkg.Name)
 		} else {
-			m.l.Trace("Package remains dirty", "package", pkg.Name, "have", p.Version, "want", pkg)
+			m.l.Trace("Package remains dirty", "package", pkg.Name, "want", pkg, "state", state)
 		}
 	}
+	graph.SetRepoStates(states, publish, clean)
 	m.l.Debug("Remaining dirty packages", "count", len(m.GetDirty(spec)))
+	return nil
 }
 
 // GetDirty returns a list of packages that are dirty in the graph.
@@ -141,16 +263,106 @@ func (m *Manager) GetDirty(spec types.SpecTuple) []*types.Package {
 	return graph.GetDirty()
 }
 
-// GetDispatchable returns a list of packages dispatchable right now.
-func (m *Manager) GetDispatchable() map[types.SpecTuple][]*types.Package {
-	atoms := make([]types.Atom, 0)
+// RevDeps returns the reverse dependencies of a package within a
+// single spec graph.
+func (m *Manager) RevDeps(spec types.SpecTuple, pkg string, transitive bool) (*RevDeps, error) {
+	graph, ok := m.graphs[spec.String()]
+	if !ok {
+		return nil, errors.New("spec not found")
+	}
+	return graph.RevDeps(pkg, transitive)
+}
+
+// Explain reports why a package in a spec graph is not dispatchable.
+func (m *Manager) Explain(spec types.SpecTuple, pkg string) (*dispatchable.Explanation, error) {
+	graph, ok := m.graphs[spec.String()]
+	if !ok {
+		return nil, errors.New("spec not found")
+	}
+	p, ok := graph.GetAtom().Pkgs[pkg]
+	if !ok {
+		return nil, errors.New("pkg not found")
+	}
+
+	e := m.newFinder().Explain(spec, p)
+	return &e, nil
+}
+
+// Plan computes the complete build plan for the dirty packages of a
+// spec.
+func (m *Manager) Plan(spec types.SpecTuple) (*dispatchable.BuildPlan, error) {
+	if _, ok := m.graphs[spec.String()]; !ok {
+		return nil, errors.New("spec not found")
+	}
+	plan := m.newFinder().Plan(spec)
+	return &plan, nil
+}
+
+// newFinder returns a DispatchFinder over all of the graphs.
+func (m *Manager) newFinder() *dispatchable.DispatchFinder {
+	atoms := make([]types.Atom, 0, len(m.graphs))
 	for _, graph := range m.graphs {
-		graph.PkgsMutex.Lock()
-		defer graph.PkgsMutex.Unlock()
 		atoms = append(atoms, graph.GetAtom())
 	}
-	finder := dispatchable.NewDispatchFinder(dispatchable.WithLogger(m.l), dispatchable.WithAtoms(atoms))
-	return finder.ImmediatelyDispatchable()
+	return dispatchable.NewDispatchFinder(dispatchable.WithLogger(m.l), dispatchable.WithAtoms(atoms))
+}
+
+// Export writes a rendering of a spec graph to w.  See
+// PkgGraph.Export for details.
+func (m *Manager) Export(w io.Writer, spec types.SpecTuple, format, root string, depth int) error {
+	graph, ok := m.graphs[spec.String()]
+	if !ok {
+		return errors.New("spec not found")
+	}
+	return graph.Export(w, format, root, depth)
+}
+
+// Diff compares a spec graph between two revisions.  An empty from
+// refers to the revision before the most recent sync, and an empty to
+// refers to the current revision.  Other revisions are loaded from
+// the stored snapshots.
+func (m *Manager) Diff(spec types.SpecTuple, from, to string) (*Diff, error) {
+	graph, ok := m.graphs[spec.String()]
+	if !ok {
+		return nil, errors.New("spec not found")
+	}
+
+	fromAtom, err := graph.atomAt(from, true)
+	if err != nil {
+		fromAtom, err = m.SnapshotAt(spec, from)
+	}
+	if err != nil {
+		return nil, err
+	}
+	toAtom, err := graph.atomAt(to, false)
+	if err != nil {
+		toAtom, err = m.SnapshotAt(spec, to)
+	}
+	if err != nil {
+		return nil, err
+	}
+	d := DiffAtoms(fromAtom, toAtom)
+	return &d, nil
+}
+
+// CacheStats returns the dump cache statistics for each spec.
+func (m *Manager) CacheStats() map[string]CacheStats {
+	return m.cache.Stats()
+}
+
+// GetUnbuildable returns a list of packages that cannot be built for
+// the spec.
+func (m *Manager) GetUnbuildable(spec types.SpecTuple) []*types.Package {
+	graph, ok := m.graphs[spec.String()]
+	if !ok {
+		return nil
+	}
+	return graph.GetUnbuildable()
+}
+
+// GetDispatchable returns a list of packages dispatchable right now.
+func (m *Manager) GetDispatchable() map[types.SpecTuple][]*types.Package {
+	return m.newFinder().ImmediatelyDispatchable()
 }
 
 func (m *Manager) loadGraphs() {
@@ -161,23 +373,47 @@ func (m *Manager) loadGraphs() {
 
 	for spec, graph := range m.graphs {
 		m.l.Debug("Attempting to load graph", "spec", spec)
-		graph.PkgsMutex.Lock()
-		graph.AuxMutex.Lock()
-		defer graph.AuxMutex.Unlock()
-		defer graph.PkgsMutex.Unlock()
 		graphbytes, err := m.storage.Get([]byte(path.Join("graph", spec)))
 		if err != nil {
 			m.l.Warn("Error loading graph", "error", err)
 			continue
 		}
-		if err := json.Unmarshal(graphbytes, &graph.atom); err != nil {
+		if graphbytes == nil {
+			continue
+		}
+		// Decode into fresh maps, the maps of the published
+		// atom must not be modified.
+		s := &graphState{atom: types.Atom{
+			Pkgs:    make(map[string]*types.Package),
+			Virtual: make(map[string]string),
+			Bad:     make(map[string]string),
+			Owners:  make(map[string]string),
+			Spec:    graph.spec,
+		}}
+		if err := json.Unmarshal(graphbytes, &s.atom); err != nil {
 			m.l.Warn("Error loading graph", "error", err)
 			continue
 		}
-		graph.PkgsMutex.Unlock()
-		defer graph.PkgsMutex.Lock() // Avoid unlocking unlocked mutex
-		graph.SetupAllSubpackages()
-		m.l.Debug("Loaded Graph", "spec", spec, "count", len(graph.atom.Pkgs), "rev", graph.atom.Rev)
+		if s.atom.Pkgs == nil {
+			s.atom.Pkgs = make(map[string]*types.Package)
+		}
+		if s.atom.Virtual == nil {
+			s.atom.Virtual = make(map[string]string)
+		}
+		if s.atom.Bad == nil {
+			s.atom.Bad = make(map[string]string)
+		}
+		if s.atom.Owners == nil {
+			s.atom.Owners = make(map[string]string)
+		}
+		graph.setupAllSubpackages(s)
+		graph.collectGarbage(s)
+		graph.reindexDeps(s)
+		graph.updateBuildable(s)
+		graph.writeMu.Lock()
+		graph.publish(s)
+		graph.writeMu.Unlock()
+		m.l.Debug("Loaded Graph", "spec", spec, "count", len(s.atom.Pkgs), "rev", s.atom.Rev)
 	}
 }
 
@@ -187,11 +423,7 @@ func (m *Manager) persistGraphs() {
 	}
 
 	for spec, graph := range m.graphs {
-		graph.PkgsMutex.Lock()
-		graph.AuxMutex.Lock()
-		defer graph.AuxMutex.Unlock()
-		defer graph.PkgsMutex.Unlock()
-		graphbytes, err := json.Marshal(graph.atom)
+		graphbytes, err := json.Marshal(graph.GetAtom())
 		if err != nil {
 			m.l.Warn("Error serializing graph", "error", err)
 			continue
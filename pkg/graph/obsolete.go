@@ -0,0 +1,173 @@
+package graph
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/the-maldridge/nbuild/pkg/types"
+	"github.com/the-maldridge/nbuild/pkg/version"
+)
+
+// generatedSuffixes are appended by xbps-src to the names of binary
+// packages that it generates without them being listed as
+// subpackages.
+var generatedSuffixes = []string{"-dbg", "-32bit"}
+
+// Obsolete compares the repository index of a target with the graph
+// and reports the binary packages that should no longer be
+// published.  Staged binaries are left alone, as are binaries whose
+// template still exists but did not load into the graph.
+func (m *Manager) Obsolete(target string) (*ObsoleteReport, error) {
+	graph := m.graphFor(target)
+	if graph == nil {
+		return nil, errors.New("target not found")
+	}
+	repos, err := m.idx.Packages(target)
+	if err != nil {
+		return nil, err
+	}
+
+	atom := graph.GetAtom()
+	out := ObsoleteReport{
+		Target:     target,
+		Rev:        atom.Rev,
+		Orphans:    []ObsoleteBinary{},
+		Downgrades: []ObsoleteBinary{},
+	}
+	for repo, pkgs := range repos {
+		for name, bin := range pkgs {
+			if bin.Staged {
+				continue
+			}
+			have := bin.Version()
+			b := ObsoleteBinary{Repo: repo, Name: name, Version: have}
+
+			p := sourceOf(atom, name)
+			if p == nil {
+				if m.templateExists(atom, name) {
+					continue
+				}
+				out.Orphans = append(out.Orphans, b)
+				continue
+			}
+			b.Template = p.Version
+			if version.Compare(have, p.Version) > 0 {
+				out.Downgrades = append(out.Downgrades, b)
+			}
+		}
+	}
+	sortBinaries(out.Orphans)
+	sortBinaries(out.Downgrades)
+	return &out, nil
+}
+
+// RemoveObsolete asks the reciever to remove the orphaned binaries of
+// a target, and the downgraded ones too if downgrades is set.  The
+// report of what was removed is returned.
+func (m *Manager) RemoveObsolete(target string, downgrades bool) (*ObsoleteReport, error) {
+	if m.reciever == nil {
+		return nil, errors.New("no reciever is configured")
+	}
+	// Every binary of a graph that has not been imported looks
+	// orphaned.
+	if graph := m.graphFor(target); graph != nil {
+		atom := graph.GetAtom()
+		if atom.Rev == "" || len(atom.Pkgs) == 0 {
+			return nil, errors.New("graph has not been imported")
+		}
+	}
+	report, err := m.Obsolete(target)
+	if err != nil {
+		return nil, err
+	}
+	if !downgrades {
+		report.Downgrades = []ObsoleteBinary{}
+	}
+
+	byRepo := make(map[string][]string)
+	for _, b := range append(append([]ObsoleteBinary{}, report.Orphans...), report.Downgrades...) {
+		byRepo[b.Repo] = append(byRepo[b.Repo], b.Name+"-"+b.Version)
+	}
+	for repo, pkgvers := range byRepo {
+		m.l.Info("Removing obsolete binaries", "target", target, "repo", repo, "count", len(pkgvers))
+		if err := m.reciever.Remove(target, repo, pkgvers); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.idx.ReloadArch(target); err != nil {
+		m.l.Warn("Error reloading index after removal", "target", target, "error", err)
+	}
+	return report, nil
+}
+
+// graphFor returns a graph that builds for the target, preferring the
+// native graph.
+func (m *Manager) graphFor(target string) *PkgGraph {
+	var found *PkgGraph
+	for _, spec := range m.specs {
+		if spec.Target != target {
+			continue
+		}
+		graph, ok := m.graphs[spec.String()]
+		if !ok {
+			continue
+		}
+		if spec.Native() {
+			return graph
+		}
+		if found == nil {
+			found = graph
+		}
+	}
+	return found
+}
+
+// sourceOf returns the source package that a binary package was
+// built from, or nil if no template produces it.
+func sourceOf(a types.Atom, name string) *types.Package {
+	if p, ok := a.Pkgs[name]; ok {
+		return p
+	}
+	for _, suffix := range generatedSuffixes {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		if p, ok := a.Pkgs[strings.TrimSuffix(name, suffix)]; ok {
+			return p
+		}
+	}
+	return nil
+}
+
+// templateExists reports whether a binary package may still be built
+// from a template even though no package in the graph produces it,
+// which happens when the template failed to load.
+func (m *Manager) templateExists(a types.Atom, name string) bool {
+	names := []string{name}
+	for _, suffix := range generatedSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			names = append(names, strings.TrimSuffix(name, suffix))
+		}
+	}
+	for _, n := range names {
+		if _, bad := a.Bad[n]; bad {
+			return true
+		}
+		if _, err := os.Lstat(filepath.Join(m.basepath, "srcpkgs", n)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func sortBinaries(b []ObsoleteBinary) {
+	sort.Slice(b, func(i, j int) bool {
+		if b[i].Repo != b[j].Repo {
+			return b[i].Repo < b[j].Repo
+		}
+		return b[i].Name < b[j].Name
+	})
+}
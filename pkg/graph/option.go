@@ -1,8 +1,11 @@
 package graph
 
 import (
+	"time"
+
 	"github.com/hashicorp/go-hclog"
 
+	"github.com/the-maldridge/nbuild/pkg/repo"
 	"github.com/the-maldridge/nbuild/pkg/storage"
 	"github.com/the-maldridge/nbuild/pkg/types"
 )
@@ -28,13 +31,37 @@ func WithSpecs(specs []types.SpecTuple) Option {
 // WithIndexURLs sets up the paths for the URLs for each index of each
 // arch in each spec.  The keys of the map should be the targets from
 // the SpecTuples.
-func WithIndexURLs(urls map[string]map[string]string) Option {
+func WithIndexURLs(urls map[string]map[string]repo.Mirrors) Option {
 	return func(m *Manager) {
-		for arch, indexes := range urls {
-			for repo, index := range indexes {
-				m.idx.LoadIndex(arch, repo, index)
-			}
-		}
+		m.indexURLs = urls
+	}
+}
+
+// WithRepoDataCache keeps a copy of each repodata file in dir so that
+// unchanged indexes need not be downloaded again, even across
+// restarts.
+func WithRepoDataCache(dir string) Option {
+	return func(m *Manager) {
+		m.repoDataCache = dir
+	}
+}
+
+// WithMaxStaleness refuses to clean graphs against repodata that has
+// not been loaded from any mirror for longer than d.  Zero disables
+// the limit.
+func WithMaxStaleness(d time.Duration) Option {
+	return func(m *Manager) {
+		m.maxStaleness = d
+	}
+}
+
+// WithTrustedKeys enables verification of repodata against the
+// fingerprints of trusted keys.  Repos named in unsigned are loaded
+// even if they are not signed with one of the keys.
+func WithTrustedKeys(keys, unsigned []string) Option {
+	return func(m *Manager) {
+		m.trustedKeys = keys
+		m.unsignedRepos = unsigned
 	}
 }
 
@@ -53,3 +80,63 @@ func WithBasePath(b string) Option {
 		m.basepath = b
 	}
 }
+
+// WithImpactRules replaces the rules used to decide how much of the
+// graph must be reimported when a path in the checkout changes.
+func WithImpactRules(rules []types.ImpactRule) Option {
+	return func(m *Manager) {
+		if len(rules) == 0 {
+			return
+		}
+		m.impactRules = rules
+	}
+}
+
+// WithSnapshotRetention controls how many historical snapshots of
+// each graph are kept, and for how long.  A zero value disables that
+// limit.
+func WithSnapshotRetention(keep int, maxAge time.Duration) Option {
+	return func(m *Manager) {
+		m.snapshotKeep = keep
+		m.snapshotMaxAge = maxAge
+	}
+}
+
+// WithTracking configures the branch of the remote that the manager
+// follows, and how often the remote is polled for changes.
+func WithTracking(branch string, interval time.Duration) Option {
+	return func(m *Manager) {
+		m.trackBranch = branch
+		if interval > 0 {
+			m.trackInterval = interval
+		}
+	}
+}
+
+// WithWebhookSecret sets the secret that push webhooks must be signed
+// with.
+func WithWebhookSecret(secret string) Option {
+	return func(m *Manager) {
+		m.webhookSecret = []byte(secret)
+	}
+}
+
+// WithRecieverURL sets the reciever that obsolete binaries are
+// removed through.
+func WithRecieverURL(url string) Option {
+	return func(m *Manager) {
+		m.recieverURL = url
+	}
+}
+
+// WithRepoStatePolicy sets whether dirty packages in each repo state
+// are built or cleaned.  Policies for states that are not listed
+// keep their defaults.
+func WithRepoStatePolicy(policies map[string]string) Option {
+	return func(m *Manager) {
+		m.repoPolicies = make(map[types.RepoState]string, len(policies))
+		for state, policy := range policies {
+			m.repoPolicies[types.RepoState(state)] = policy
+		}
+	}
+}
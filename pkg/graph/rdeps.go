@@ -0,0 +1,158 @@
+package graph
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/the-maldridge/nbuild/pkg/types"
+	"github.com/the-maldridge/nbuild/pkg/version"
+)
+
+// indexDeps adds the forward edges of a source package to the
+// reverse dependency index of a state.
+func (t *PkgGraph) indexDeps(s *graphState, p *types.Package) {
+	if p == nil {
+		return
+	}
+	rdeps := s.index()
+	for kind, deps := range p.DepsByKind() {
+		for dep := range deps {
+			name := depName(dep)
+			if _, ok := rdeps[name]; !ok {
+				rdeps[name] = make(map[string]map[types.DepKind]struct{})
+			}
+			if _, ok := rdeps[name][p.Name]; !ok {
+				rdeps[name][p.Name] = make(map[types.DepKind]struct{})
+			}
+			rdeps[name][p.Name][kind] = struct{}{}
+		}
+	}
+}
+
+// unindexDeps removes the forward edges of a source package from the
+// reverse dependency index of a state.
+func (t *PkgGraph) unindexDeps(s *graphState, p *types.Package) {
+	if p == nil {
+		return
+	}
+	rdeps := s.index()
+	for _, deps := range p.DepsByKind() {
+		for dep := range deps {
+			name := depName(dep)
+			delete(rdeps[name], p.Name)
+			if len(rdeps[name]) == 0 {
+				delete(rdeps, name)
+			}
+		}
+	}
+}
+
+// reindexDeps throws away the reverse dependency index of a state
+// and rebuilds it from the atom.  This is used after loading a graph
+// from storage.
+func (t *PkgGraph) reindexDeps(s *graphState) {
+	s.rdeps = make(revIndex)
+	s.ownIndex = true
+	for name, p := range s.atom.Pkgs {
+		if name != p.Name {
+			// Subpackages point at their parent and
+			// would be counted twice.
+			continue
+		}
+		t.indexDeps(s, p)
+	}
+}
+
+// RevDeps returns the packages that depend on the named package.
+// When transitive is set the full set of packages that would be
+// affected by a change to the named package is returned.  Host
+// dependency edges are recorded against the graph the dependent
+// package lives in, so on a cross graph they refer to packages of
+// the host arch.
+func (t *PkgGraph) RevDeps(name string, transitive bool) (*RevDeps, error) {
+	s := t.load()
+	root, ok := s.atom.Pkgs[name]
+	if !ok {
+		return nil, errors.New("pkg not found")
+	}
+
+	found := make(map[string]map[types.DepKind]struct{})
+	seen := map[string]struct{}{root.Name: {}}
+	queue := []*types.Package{root}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+
+		for _, alias := range aliases(s.atom, p) {
+			for dependent, kinds := range s.rdeps[alias] {
+				if dependent == p.Name {
+					// Packages routinely depend on
+					// their own subpackages.
+					continue
+				}
+				if _, ok := found[dependent]; !ok {
+					found[dependent] = make(map[types.DepKind]struct{})
+				}
+				for k := range kinds {
+					found[dependent][k] = struct{}{}
+				}
+				if _, ok := seen[dependent]; ok || !transitive {
+					continue
+				}
+				seen[dependent] = struct{}{}
+				if dp, ok := s.atom.Pkgs[dependent]; ok {
+					queue = append(queue, dp)
+				}
+			}
+		}
+	}
+	delete(found, root.Name)
+
+	out := RevDeps{
+		Package:    name,
+		Transitive: transitive,
+		Pkgs:       make(map[string][]types.DepKind, len(found)),
+		ByKind:     make(map[types.DepKind][]string),
+	}
+	for dependent, kinds := range found {
+		for k := range kinds {
+			out.Pkgs[dependent] = append(out.Pkgs[dependent], k)
+			out.ByKind[k] = append(out.ByKind[k], dependent)
+		}
+		sort.Slice(out.Pkgs[dependent], func(i, j int) bool {
+			return out.Pkgs[dependent][i] < out.Pkgs[dependent][j]
+		})
+	}
+	for k := range out.ByKind {
+		sort.Strings(out.ByKind[k])
+	}
+	return &out, nil
+}
+
+// aliases returns every name that refers to the given source
+// package: its own name, its subpackages, and any virtual names that
+// default to one of those.
+func aliases(a types.Atom, p *types.Package) []string {
+	names := map[string]struct{}{p.Name: {}}
+	for sub := range p.Subpackages {
+		names[sub] = struct{}{}
+	}
+	for virtual, provider := range a.Virtual {
+		if _, ok := names[provider]; ok {
+			names[virtual] = struct{}{}
+		}
+	}
+
+	out := make([]string, 0, len(names))
+	for n := range names {
+		out = append(out, n)
+	}
+	return out
+}
+
+// depName reduces a dependency string as it appears in a template to
+// the bare name of the package it refers to.
+func depName(dep string) string {
+	return version.PatternName(strings.TrimPrefix(dep, "virtual?"))
+}
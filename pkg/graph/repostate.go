@@ -0,0 +1,97 @@
+package graph
+
+import (
+	"github.com/the-maldridge/nbuild/pkg/repo"
+	"github.com/the-maldridge/nbuild/pkg/types"
+	"github.com/the-maldridge/nbuild/pkg/version"
+)
+
+// Policies decide what happens to a dirty package in each repo state.
+const (
+	// PolicyBuild leaves the package dirty so that it is built.
+	PolicyBuild = "build"
+
+	// PolicyClean marks the package clean so that the binary in
+	// the repo is used as is.
+	PolicyClean = "clean"
+)
+
+// defaultRepoPolicy rebuilds packages that the repo does not have an
+// up to date binary for, and keeps binaries that are ahead of their
+// template rather than building a downgrade.
+var defaultRepoPolicy = map[types.RepoState]string{
+	types.RepoMissing: PolicyBuild,
+	types.RepoOlder:   PolicyBuild,
+	types.RepoEqual:   PolicyClean,
+	types.RepoNewer:   PolicyClean,
+}
+
+// repoState compares the version of a package in the index of a
+// target with the version of its template, and reports whether that
+// version is published.  A staged binary counts as built, so it
+// decides the repo state even though it is not yet published.
+func (m *Manager) repoState(target string, pkg *types.Package) (types.RepoState, types.PublishState, error) {
+	p, err := m.idx.GetPackage(target, pkg.Name)
+	if err == repo.ErrNoSuchPackage {
+		return types.RepoMissing, "", nil
+	} else if err != nil {
+		return "", "", err
+	}
+
+	// The version field in the index includes the package
+	// name.
+	name, _ := version.PkgName(p.Pkgver)
+	if name != pkg.Name {
+		return types.RepoMissing, "", nil
+	}
+	publish := types.PublishPublished
+	if p.Staged {
+		publish = types.PublishStaged
+	}
+	switch version.Compare(p.Version(), pkg.Version) {
+	case -1:
+		return types.RepoOlder, publish, nil
+	case 1:
+		return types.RepoNewer, publish, nil
+	}
+	return types.RepoEqual, publish, nil
+}
+
+// repoPolicy returns the policy for a repo state.
+func (m *Manager) repoPolicy(state types.RepoState) string {
+	if p, ok := m.repoPolicies[state]; ok {
+		return p
+	}
+	return defaultRepoPolicy[state]
+}
+
+// SetRepoStates records the repo and publish state of the named
+// packages and clears the dirty bit on the packages listed in clean,
+// all in a single update.
+func (t *PkgGraph) SetRepoStates(states map[string]types.RepoState, publish map[string]types.PublishState, clean []string) {
+	t.update(func(s *graphState) {
+		for name, state := range states {
+			p, ok := s.atom.Pkgs[name]
+			if !ok || (p.RepoState == state && p.Publish == publish[name]) {
+				continue
+			}
+			s.mutatePkg(name, func(p *types.Package) {
+				p.RepoState = state
+				p.Publish = publish[name]
+			})
+		}
+		for _, name := range clean {
+			p, ok := s.atom.Pkgs[name]
+			if !ok {
+				t.l.Warn("Attempted clean on non-existant package!", "package", name)
+				continue
+			}
+			if !p.Dirty {
+				continue
+			}
+			s.mutatePkg(name, func(p *types.Package) { p.Dirty = false })
+			s.notify(Event{Type: EventCleaned, Package: p.Name})
+			t.l.Trace("Cleaned package", "package", name)
+		}
+	})
+}
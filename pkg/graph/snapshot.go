@@ -0,0 +1,161 @@
+package graph
+
+import (
+	"encoding/json"
+	"errors"
+	"path"
+	"time"
+
+	"github.com/the-maldridge/nbuild/pkg/types"
+)
+
+// snapshot stores an immutable copy of an atom keyed by its spec and
+// revision.  Snapshots are taken as a graph moves away from a
+// revision so that they reflect the final state reached at that
+// revision.  A revision that already has a snapshot is never
+// overwritten.
+func (m *Manager) snapshot(atom types.Atom) {
+	if m.storage == nil || atom.Rev == "" {
+		return
+	}
+
+	m.snapMutex.Lock()
+	defer m.snapMutex.Unlock()
+
+	index, err := m.snapshotIndex(atom.Spec)
+	if err != nil {
+		m.l.Warn("Error loading snapshot index", "spec", atom.Spec, "error", err)
+		return
+	}
+	for _, s := range index {
+		if s.Rev == atom.Rev {
+			return
+		}
+	}
+
+	atombytes, err := json.Marshal(atom)
+	if err != nil {
+		m.l.Warn("Error serializing snapshot", "spec", atom.Spec, "error", err)
+		return
+	}
+	if err := m.storage.Put(snapshotKey(atom.Spec, atom.Rev), atombytes); err != nil {
+		m.l.Warn("Error writing snapshot", "spec", atom.Spec, "error", err)
+		return
+	}
+
+	info := SnapshotInfo{
+		Rev:  atom.Rev,
+		Time: time.Now(),
+	}
+	for name, p := range atom.Pkgs {
+		if name != p.Name {
+			continue
+		}
+		info.Pkgs++
+		if p.Dirty && !p.Failed {
+			info.Dirty++
+		}
+	}
+	index = m.pruneSnapshots(atom.Spec, append(index, info))
+
+	indexbytes, err := json.Marshal(index)
+	if err != nil {
+		m.l.Warn("Error serializing snapshot index", "spec", atom.Spec, "error", err)
+		return
+	}
+	if err := m.storage.Put(snapshotIndexKey(atom.Spec), indexbytes); err != nil {
+		m.l.Warn("Error writing snapshot index", "spec", atom.Spec, "error", err)
+		return
+	}
+	m.l.Debug("Stored snapshot", "spec", atom.Spec, "rev", atom.Rev)
+}
+
+// pruneSnapshots applies the retention policy to the index, removing
+// the atoms of expired snapshots from storage.  The index must be
+// ordered from oldest to newest.
+func (m *Manager) pruneSnapshots(spec types.SpecTuple, index []SnapshotInfo) []SnapshotInfo {
+	keep := make([]SnapshotInfo, 0, len(index))
+	for i, s := range index {
+		expired := m.snapshotKeep > 0 && len(index)-i > m.snapshotKeep
+		if m.snapshotMaxAge > 0 && time.Since(s.Time) > m.snapshotMaxAge {
+			expired = true
+		}
+		if !expired {
+			keep = append(keep, s)
+			continue
+		}
+		if err := m.storage.Del(snapshotKey(spec, s.Rev)); err != nil {
+			m.l.Warn("Error removing snapshot", "spec", spec, "rev", s.Rev, "error", err)
+		}
+		m.l.Debug("Expired snapshot", "spec", spec, "rev", s.Rev)
+	}
+	return keep
+}
+
+// Snapshots lists the snapshots that are stored for a spec, oldest
+// first.
+func (m *Manager) Snapshots(spec types.SpecTuple) ([]SnapshotInfo, error) {
+	if _, ok := m.graphs[spec.String()]; !ok {
+		return nil, errors.New("spec not found")
+	}
+	if m.storage == nil {
+		return []SnapshotInfo{}, nil
+	}
+
+	m.snapMutex.Lock()
+	defer m.snapMutex.Unlock()
+	return m.snapshotIndex(spec)
+}
+
+// SnapshotAt returns the atom of a spec as of the given revision.
+// The live graph is returned if it is at that revision.
+func (m *Manager) SnapshotAt(spec types.SpecTuple, rev string) (types.Atom, error) {
+	graph, ok := m.graphs[spec.String()]
+	if !ok {
+		return types.Atom{}, errors.New("spec not found")
+	}
+	if cur := graph.GetAtom(); cur.Rev == rev {
+		return cur, nil
+	}
+	if m.storage == nil {
+		return types.Atom{}, errors.New("revision not available")
+	}
+
+	atombytes, err := m.storage.Get(snapshotKey(spec, rev))
+	if err != nil {
+		return types.Atom{}, err
+	}
+	if atombytes == nil {
+		return types.Atom{}, errors.New("revision not available")
+	}
+	atom := types.Atom{}
+	if err := json.Unmarshal(atombytes, &atom); err != nil {
+		return types.Atom{}, err
+	}
+	return atom, nil
+}
+
+// snapshotIndex loads the list of snapshots for a spec.  The caller
+// must hold snapMutex.
+func (m *Manager) snapshotIndex(spec types.SpecTuple) ([]SnapshotInfo, error) {
+	index := []SnapshotInfo{}
+	indexbytes, err := m.storage.Get(snapshotIndexKey(spec))
+	if err != nil {
+		return nil, err
+	}
+	if indexbytes == nil {
+		return index, nil
+	}
+	if err := json.Unmarshal(indexbytes, &index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+func snapshotKey(spec types.SpecTuple, rev string) []byte {
+	return []byte(path.Join("snapshot", spec.String(), rev))
+}
+
+func snapshotIndexKey(spec types.SpecTuple) []byte {
+	return []byte(path.Join("snapshots", spec.String()))
+}
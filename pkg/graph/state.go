@@ -0,0 +1,107 @@
+package graph
+
+import (
+	"github.com/the-maldridge/nbuild/pkg/types"
+)
+
+// load returns the most recently published state of the graph.  The
+// state must not be modified.
+func (t *PkgGraph) load() *graphState {
+	return t.state.Load().(*graphState)
+}
+
+// publish makes a state visible to readers.  The caller must hold
+// writeMu unless the graph is not yet shared.
+func (t *PkgGraph) publish(s *graphState) {
+	t.state.Store(s)
+}
+
+// update applies fn to a copy of the current state and then
+// publishes the copy.  Writers are serialized so that no update is
+// lost, while readers continue to use the old state until the new
+// one is published.
+func (t *PkgGraph) update(fn func(*graphState)) {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	next := t.load().clone()
+	fn(next)
+	events := next.pending
+	next.pending = nil
+	t.publish(next)
+	t.emit(events)
+}
+
+// clone returns a copy of the state that may be modified.  The maps
+// of the atom are copied but the packages themselves are shared, so
+// a package must be replaced with setPkg rather than modified in
+// place.  The reverse dependency index is copied the first time it is
+// modified.
+func (s *graphState) clone() *graphState {
+	c := *s
+	c.atom.Pkgs = make(map[string]*types.Package, len(s.atom.Pkgs))
+	for name, p := range s.atom.Pkgs {
+		c.atom.Pkgs[name] = p
+	}
+	c.atom.Virtual = copyStrings(s.atom.Virtual)
+	c.atom.Owners = copyStrings(s.atom.Owners)
+	c.atom.Bad = copyStrings(s.atom.Bad)
+	c.ownIndex = false
+	return &c
+}
+
+// index returns the reverse dependency index for modification,
+// copying it first if it is still shared with a published state.
+func (s *graphState) index() revIndex {
+	if s.ownIndex {
+		return s.rdeps
+	}
+	c := make(revIndex, len(s.rdeps))
+	for dep, dependents := range s.rdeps {
+		c[dep] = make(map[string]map[types.DepKind]struct{}, len(dependents))
+		for name, kinds := range dependents {
+			c[dep][name] = make(map[types.DepKind]struct{}, len(kinds))
+			for k := range kinds {
+				c[dep][name][k] = struct{}{}
+			}
+		}
+	}
+	s.rdeps = c
+	s.ownIndex = true
+	return c
+}
+
+// setPkg replaces a source package, along with every subpackage
+// entry that points at the package it replaces.
+func (s *graphState) setPkg(p *types.Package) {
+	old := s.atom.Pkgs[p.Name]
+	s.atom.Pkgs[p.Name] = p
+	if old == nil {
+		return
+	}
+	for sub := range old.Subpackages {
+		if s.atom.Pkgs[sub] == old {
+			s.atom.Pkgs[sub] = p
+		}
+	}
+}
+
+// mutatePkg replaces the named package with a copy that fn has
+// modified.  It returns false if the package does not exist.
+func (s *graphState) mutatePkg(name string, fn func(*types.Package)) bool {
+	p, ok := s.atom.Pkgs[name]
+	if !ok {
+		return false
+	}
+	c := p.Copy()
+	fn(c)
+	s.setPkg(c)
+	return true
+}
+
+func copyStrings(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
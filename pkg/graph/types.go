@@ -3,9 +3,12 @@ package graph
 import (
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 
+	"github.com/the-maldridge/nbuild/pkg/reciever"
 	"github.com/the-maldridge/nbuild/pkg/repo"
 	"github.com/the-maldridge/nbuild/pkg/storage"
 	"github.com/the-maldridge/nbuild/pkg/types"
@@ -13,18 +16,126 @@ import (
 
 // PkgGraph contains a tree of packages
 type PkgGraph struct {
-	// Lock for source pkgs map
-	PkgsMutex *sync.Mutex
+	// state holds the current *graphState.  Readers load it
+	// without locking, writers publish a modified copy.
+	state atomic.Value
 
-	// Lock for auxiliary maps
-	AuxMutex *sync.Mutex
+	// writeMu serializes writers so that no update is lost.
+	writeMu *sync.Mutex
 
 	l hclog.Logger
 
+	spec        types.SpecTuple
 	basePath    string
 	parallelism int
 
-	atom types.Atom
+	cache  *dumpCache
+	events *eventBroker
+}
+
+// graphState is a consistent view of a graph.  Once published a state
+// and the packages it refers to are never modified.
+type graphState struct {
+	atom  types.Atom
+	rdeps revIndex
+
+	// ownIndex is set once rdeps has been copied for this state
+	// and may be modified.
+	ownIndex bool
+
+	// prev holds the atom as it was before the most recent
+	// sync so that the sync can be diffed.
+	prev *types.Atom
+
+	// pending holds the events caused by the changes to this
+	// state, which are sent once it is published.
+	pending []Event
+}
+
+// importResult holds the packages loaded by an import before they
+// are applied to the graph.
+type importResult struct {
+	mu      *sync.Mutex
+	pkgs    []*types.Package
+	bad     map[string]string
+	errs    map[string]string
+	removed []string
+}
+
+// An Event describes a change to the state of the graphs.  Fields
+// that do not apply to the type of event are left empty.
+type Event struct {
+	Type    string
+	Time    time.Time
+	Spec    string              `json:",omitempty"`
+	Package string              `json:",omitempty"`
+	Rev     string              `json:",omitempty"`
+	Mode    string              `json:",omitempty"`
+	Error   string              `json:",omitempty"`
+	Pkgs    map[string][]string `json:",omitempty"`
+}
+
+// eventBroker fans events out to the subscribers of the event
+// stream.
+type eventBroker struct {
+	l    hclog.Logger
+	mu   *sync.Mutex
+	subs map[chan Event]struct{}
+
+	// dispatchable is the set of dispatchable packages that
+	// subscribers were last told about.
+	dispatchable map[string][]string
+}
+
+// revIndex maps the name of a dependency to the source packages that
+// depend on it and the kinds of edges they depend on it through.
+type revIndex map[string]map[string]map[types.DepKind]struct{}
+
+// An Edge is a single dependency between two source packages.
+type Edge struct {
+	From string
+	To   string
+	Kind types.DepKind
+}
+
+// A Diff describes the changes between two atoms of the same spec.
+type Diff struct {
+	Spec types.SpecTuple
+	From string
+	To   string
+
+	Added   []string
+	Removed []string
+	Changed []VersionChange
+
+	EdgesAdded   []Edge
+	EdgesRemoved []Edge
+
+	// Dirtied and Cleaned list the packages whose dirty state
+	// changed.
+	Dirtied []string
+	Cleaned []string
+}
+
+// A VersionChange records the old and new version of a package.
+type VersionChange struct {
+	Name string
+	From string
+	To   string
+}
+
+// RevDeps is the result of a reverse dependency query.
+type RevDeps struct {
+	Package    string
+	Transitive bool
+
+	// Pkgs maps each dependent package to the kinds of edges
+	// that lead to it.
+	Pkgs map[string][]types.DepKind
+
+	// ByKind breaks the dependent packages out by the kind of
+	// edge that leads to them.
+	ByKind map[types.DepKind][]string
 }
 
 // Manager is a collection of graphs that all interact with the same
@@ -36,9 +147,92 @@ type Manager struct {
 	specs    []types.SpecTuple
 	idx      *repo.IndexService
 	basepath string
-	rev      string
+	rev      atomic.Value
+
+	indexURLs     map[string]map[string]repo.Mirrors
+	repoDataCache string
+	maxStaleness  time.Duration
+	trustedKeys   []string
+	unsignedRepos []string
+
+	storage storage.Storage
+	cache   *dumpCache
+
+	impactRules []types.ImpactRule
 
+	// repoPolicies overrides the default policy for each repo
+	// state.
+	repoPolicies map[types.RepoState]string
+
+	snapMutex      *sync.Mutex
+	snapshotKeep   int
+	snapshotMaxAge time.Duration
+
+	// syncMutex serializes syncs, which may be requested by the
+	// API and the follower at the same time.
+	syncMutex     *sync.Mutex
+	trackBranch   string
+	trackInterval time.Duration
+
+	// stopFollow is closed once to stop the follower, which
+	// closes followDone when it has returned.  followMutex guards
+	// followDone.
+	stopFollow  chan struct{}
+	stopOnce    *sync.Once
+	followMutex *sync.Mutex
+	followDone  chan struct{}
+
+	events *eventBroker
+
+	recieverURL string
+	reciever    *reciever.APIClient
+
+	webhookSecret []byte
+	hookMutex     *sync.Mutex
+	deliveries    []string
+	lastPush      string
+	seenHeads     map[string]struct{}
+	seenOrder     []string
+	hookQueue     chan hookSync
+}
+
+// SnapshotInfo describes a stored snapshot of an atom.
+type SnapshotInfo struct {
+	Rev   string
+	Time  time.Time
+	Pkgs  int
+	Dirty int
+}
+
+// importPlan is the result of classifying a set of changed paths.
+// Either a full import is required, or only the listed paths need to
+// be reimported.
+type importPlan struct {
+	full  bool
+	paths []string
+}
+
+// dumpCache stores the raw output of xbps-src dbulk-dump so that
+// packages whose inputs have not changed need not be dumped again.
+type dumpCache struct {
+	l       hclog.Logger
 	storage storage.Storage
+
+	mu    *sync.Mutex
+	stats map[string]*CacheStats
+}
+
+// CacheStats reports how effective the dump cache has been for a
+// single spec.
+type CacheStats struct {
+	Hits   int
+	Misses int
+}
+
+// cachedDump is the serialized form of a single cache entry.
+type cachedDump struct {
+	Sum  string
+	Dump []byte
 }
 
 // APIClient embodies the client to the HTTP API
@@ -56,7 +250,57 @@ type CheckoutManager interface {
 	Fetch() error
 	Checkout(string) ([]string, error)
 	At() (string, error)
+	RemoteHead(string) (string, error)
+	IsAncestor(string, string) (bool, error)
 }
 
 // Option allows the manager to be configured in a nice dynamic way.
 type Option func(*Manager)
+
+// pushEvent is the subset of a GitHub or Gitea push payload that is
+// needed to decide whether to sync.
+type pushEvent struct {
+	Ref    string
+	Before string
+	After  string
+	Forced bool
+}
+
+// hookSync is a push waiting to be synced.
+type hookSync struct {
+	delivery string
+	push     pushEvent
+}
+
+// WebhookStatus reports what was done with a push delivery.
+type WebhookStatus struct {
+	Delivery   string
+	Ref        string
+	Rev        string
+	Accepted   bool
+	Duplicate  bool
+	OutOfOrder bool
+	Message    string
+}
+
+// ObsoleteReport lists the binary packages in the repository index of
+// a target that no longer match the graph.
+type ObsoleteReport struct {
+	Target string
+	Rev    string
+
+	// Orphans are built from templates that no longer exist.
+	Orphans []ObsoleteBinary
+
+	// Downgrades are newer than the version of their template.
+	Downgrades []ObsoleteBinary
+}
+
+// An ObsoleteBinary is a single binary package in a repo.  Template
+// holds the version of the template that produces it, if any.
+type ObsoleteBinary struct {
+	Repo     string
+	Name     string
+	Version  string
+	Template string `json:",omitempty"`
+}
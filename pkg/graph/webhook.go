@@ -0,0 +1,249 @@
+package graph
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxDeliveries is the number of delivery IDs remembered for
+// detecting redelivered webhooks, and maxSeenHeads the number of
+// pushed revisions remembered for detecting stale pushes.
+const (
+	maxDeliveries = 256
+	maxSeenHeads  = 256
+)
+
+// hookQueueLen bounds the number of pushes waiting to be synced.
+const hookQueueLen = 64
+
+// maxPayload bounds the size of a webhook body that will be read.
+const maxPayload = 25 << 20
+
+// zeroHash is sent as the before or after hash when a branch is
+// created or deleted.
+const zeroHash = "0000000000000000000000000000000000000000"
+
+// WebhookEntry provides the mountpoint for push webhooks.  Pushes
+// to the tracked branch that carry a valid signature cause the graph
+// to be synced to the pushed revision.
+func (m *Manager) WebhookEntry() chi.Router {
+	r := chi.NewRouter()
+
+	r.Post("/push", m.httpPushHook)
+
+	return r
+}
+
+func (m *Manager) httpPushHook(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPayload))
+	if err != nil {
+		jsonError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := m.verifySignature(r, body); err != nil {
+		m.l.Warn("Rejected webhook", "error", err, "remote", r.RemoteAddr)
+		jsonError(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	switch event := hookEvent(r); event {
+	case "", "push":
+	case "ping":
+		// Forges send a ping when a hook is created, and show
+		// the hook as failing unless it succeeds.
+		enc := json.NewEncoder(w)
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "application/json")
+		enc.Encode(WebhookStatus{Delivery: hookDelivery(r), Message: "pong"})
+		return
+	default:
+		jsonError(w, errors.New("unsupported event: "+event), http.StatusBadRequest)
+		return
+	}
+
+	push := pushEvent{}
+	if err := json.Unmarshal(body, &push); err != nil {
+		jsonError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	status := m.handlePush(hookDelivery(r), push)
+	code := http.StatusOK
+	if status.Accepted {
+		code = http.StatusAccepted
+	}
+
+	enc := json.NewEncoder(w)
+	w.WriteHeader(code)
+	w.Header().Set("Content-Type", "application/json")
+	enc.Encode(status)
+}
+
+// handlePush decides what to do with a verified push and queues a
+// sync if one is needed.
+func (m *Manager) handlePush(delivery string, push pushEvent) WebhookStatus {
+	status := WebhookStatus{Delivery: delivery, Ref: push.Ref, Rev: push.After}
+
+	if m.trackBranch == "" {
+		status.Message = "no branch is tracked"
+		return status
+	}
+	if push.Ref != "refs/heads/"+m.trackBranch {
+		status.Message = "ref is not the tracked branch"
+		return status
+	}
+	if push.After == "" || push.After == zeroHash {
+		status.Message = "branch was deleted"
+		return status
+	}
+
+	m.hookMutex.Lock()
+	defer m.hookMutex.Unlock()
+
+	if delivery != "" {
+		for _, d := range m.deliveries {
+			if d == delivery {
+				m.l.Info("Duplicate webhook delivery", "delivery", delivery)
+				status.Duplicate = true
+				status.Message = "delivery already processed"
+				return status
+			}
+		}
+		m.deliveries = append(m.deliveries, delivery)
+		if len(m.deliveries) > maxDeliveries {
+			m.deliveries = m.deliveries[len(m.deliveries)-maxDeliveries:]
+		}
+	}
+
+	if _, seen := m.seenHeads[push.After]; seen || push.After == m.Rev() {
+		m.l.Info("Stale webhook delivery", "delivery", delivery, "rev", push.After)
+		status.OutOfOrder = true
+		status.Message = "revision has already been seen"
+		return status
+	}
+
+	last := m.lastPush
+	if last == "" {
+		last = m.Rev()
+	}
+	if push.Before != last && push.Before != zeroHash {
+		// A push was missed or is arriving late.  Whether it
+		// is still newer than the graph can only be decided
+		// once the checkout has been fetched, so the sync
+		// checks its ancestry before applying it.
+		m.l.Warn("Out of order webhook delivery", "delivery", delivery, "before", push.Before, "expected", last)
+		status.OutOfOrder = true
+		status.Message = "push does not follow the last seen revision"
+	}
+
+	select {
+	case m.hookQueue <- hookSync{delivery: delivery, push: push}:
+	default:
+		status.Message = "too many pushes are waiting to be synced"
+		return status
+	}
+	m.seeHead(push.After)
+	m.lastPush = push.After
+
+	status.Accepted = true
+	return status
+}
+
+// seeHead remembers a pushed revision, forgetting the oldest once
+// there are too many.  The caller must hold hookMutex.
+func (m *Manager) seeHead(rev string) {
+	m.seenHeads[rev] = struct{}{}
+	m.seenOrder = append(m.seenOrder, rev)
+	if len(m.seenOrder) > maxSeenHeads {
+		for _, old := range m.seenOrder[:len(m.seenOrder)-maxSeenHeads] {
+			delete(m.seenHeads, old)
+		}
+		m.seenOrder = m.seenOrder[len(m.seenOrder)-maxSeenHeads:]
+	}
+}
+
+// syncHooks applies queued pushes one at a time, in the order they
+// were received, until the queue is closed.
+func (m *Manager) syncHooks() {
+	for h := range m.hookQueue {
+		m.syncFromHook(h.delivery, h.push)
+	}
+}
+
+// syncFromHook fetches and syncs to a pushed revision.  Pushes that
+// do not descend from the current revision arrived after a later
+// push was applied and are dropped, unless they were forced.
+func (m *Manager) syncFromHook(delivery string, push pushEvent) {
+	rev := push.After
+	if err := m.UpdateCheckout(); err != nil {
+		m.l.Warn("Error updating", "error", err, "delivery", delivery)
+		return
+	}
+	if cur := m.Rev(); cur != "" && cur != rev && !push.Forced {
+		ok, err := m.cm.IsAncestor(cur, rev)
+		if err != nil {
+			m.l.Warn("Unable to check ancestry of push", "error", err, "delivery", delivery, "rev", rev)
+			return
+		}
+		if !ok {
+			m.l.Info("Dropping push that does not descend from the current revision", "delivery", delivery, "rev", rev, "current", cur)
+			return
+		}
+	}
+	if _, err := m.SyncTo(rev); err != nil {
+		m.l.Warn("Error syncing from webhook", "error", err, "delivery", delivery, "rev", rev)
+		return
+	}
+	m.Clean()
+	m.l.Info("Published revision", "rev", rev, "delivery", delivery)
+}
+
+// verifySignature checks the HMAC of the body against the signature
+// sent by GitHub or Gitea.
+func (m *Manager) verifySignature(r *http.Request, body []byte) error {
+	if len(m.webhookSecret) == 0 {
+		return errors.New("no webhook secret is configured")
+	}
+
+	sig := strings.TrimPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+	if sig == "" {
+		sig = r.Header.Get("X-Gitea-Signature")
+	}
+	if sig == "" {
+		return errors.New("request is not signed")
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return errors.New("malformed signature")
+	}
+
+	mac := hmac.New(sha256.New, m.webhookSecret)
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+func hookEvent(r *http.Request) string {
+	if e := r.Header.Get("X-GitHub-Event"); e != "" {
+		return e
+	}
+	return r.Header.Get("X-Gitea-Event")
+}
+
+func hookDelivery(r *http.Request) string {
+	if d := r.Header.Get("X-GitHub-Delivery"); d != "" {
+		return d
+	}
+	return r.Header.Get("X-Gitea-Delivery")
+}
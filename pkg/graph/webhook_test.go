@@ -0,0 +1,229 @@
+package graph
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeCheckout is a CheckoutManager over a linear history in which
+// each revision descends from every earlier one.
+type fakeCheckout struct {
+	history   []string
+	checkouts []string
+}
+
+func (f *fakeCheckout) SetBasepath(string)                {}
+func (f *fakeCheckout) Bootstrap() error                  { return nil }
+func (f *fakeCheckout) Fetch() error                      { return nil }
+func (f *fakeCheckout) At() (string, error)               { return "", nil }
+func (f *fakeCheckout) RemoteHead(string) (string, error) { return "", nil }
+func (f *fakeCheckout) Checkout(rev string) ([]string, error) {
+	f.checkouts = append(f.checkouts, rev)
+	return nil, nil
+}
+
+func (f *fakeCheckout) IsAncestor(ancestor, descendant string) (bool, error) {
+	a, d := -1, -1
+	for i, rev := range f.history {
+		if rev == ancestor {
+			a = i
+		}
+		if rev == descendant {
+			d = i
+		}
+	}
+	if a < 0 || d < 0 {
+		return false, fmt.Errorf("unknown revision")
+	}
+	return a <= d, nil
+}
+
+const testSecret = "s3cret"
+
+// newHookManager returns a manager that tracks master.  Queued pushes
+// are left in the queue rather than synced so that they can be
+// inspected.
+func newHookManager(history ...string) (*Manager, *fakeCheckout) {
+	m := NewManager(WithTracking("master", 0))
+	cm := &fakeCheckout{history: history}
+	m.cm = cm
+	m.webhookSecret = []byte(testSecret)
+	m.hookQueue = make(chan hookSync, hookQueueLen)
+	return m, cm
+}
+
+func sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func pushBody(t *testing.T, ref, before, after string) []byte {
+	body, err := json.Marshal(map[string]interface{}{
+		"ref":    ref,
+		"before": before,
+		"after":  after,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestWebhookSignature(t *testing.T) {
+	body := pushBody(t, "refs/heads/master", zeroHash, "aaa")
+	cases := []struct {
+		name   string
+		header string
+		value  string
+		want   int
+	}{
+		{"github", "X-Hub-Signature-256", sign(body), http.StatusAccepted},
+		{"gitea", "X-Gitea-Signature", sign(body)[len("sha256="):], http.StatusAccepted},
+		{"unsigned", "", "", http.StatusUnauthorized},
+		{"wrong secret", "X-Hub-Signature-256", "sha256=" + hex.EncodeToString(make([]byte, 32)), http.StatusUnauthorized},
+		{"malformed", "X-Hub-Signature-256", "sha256=zz", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		m, _ := newHookManager("aaa")
+		req := httptest.NewRequest("POST", "/push", bytes.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "push")
+		if c.header != "" {
+			req.Header.Set(c.header, c.value)
+		}
+		w := httptest.NewRecorder()
+		m.WebhookEntry().ServeHTTP(w, req)
+		if w.Code != c.want {
+			t.Errorf("%s: got status %d, want %d", c.name, w.Code, c.want)
+		}
+	}
+}
+
+func TestWebhookEvents(t *testing.T) {
+	body := []byte(`{"zen":"Keep it logically awesome."}`)
+	cases := []struct {
+		header string
+		event  string
+		want   int
+	}{
+		{"X-GitHub-Event", "ping", http.StatusOK},
+		{"X-Gitea-Event", "ping", http.StatusOK},
+		{"X-GitHub-Event", "issues", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		m, _ := newHookManager("aaa")
+		req := httptest.NewRequest("POST", "/push", bytes.NewReader(body))
+		req.Header.Set(c.header, c.event)
+		req.Header.Set("X-Hub-Signature-256", sign(body))
+		w := httptest.NewRecorder()
+		m.WebhookEntry().ServeHTTP(w, req)
+		if w.Code != c.want {
+			t.Errorf("%s %s: got status %d, want %d", c.header, c.event, w.Code, c.want)
+		}
+		if len(m.hookQueue) != 0 {
+			t.Errorf("%s %s: event was queued", c.header, c.event)
+		}
+	}
+
+	// A ping must still be signed.
+	m, _ := newHookManager("aaa")
+	req := httptest.NewRequest("POST", "/push", bytes.NewReader(body))
+	req.Header.Set("X-GitHub-Event", "ping")
+	w := httptest.NewRecorder()
+	m.WebhookEntry().ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned ping: got status %d", w.Code)
+	}
+}
+
+func TestWebhookDuplicateDelivery(t *testing.T) {
+	m, _ := newHookManager("aaa", "bbb")
+	m.setRev("aaa")
+
+	first := m.handlePush("delivery-1", pushEvent{Ref: "refs/heads/master", Before: "aaa", After: "bbb"})
+	if !first.Accepted {
+		t.Fatalf("first delivery was not accepted: %s", first.Message)
+	}
+	second := m.handlePush("delivery-1", pushEvent{Ref: "refs/heads/master", Before: "aaa", After: "bbb"})
+	if second.Accepted || !second.Duplicate {
+		t.Errorf("redelivery was not detected: %+v", second)
+	}
+	if len(m.hookQueue) != 1 {
+		t.Errorf("got %d queued syncs, want 1", len(m.hookQueue))
+	}
+}
+
+func TestWebhookRef(t *testing.T) {
+	m, _ := newHookManager("aaa")
+	for _, ref := range []string{"refs/heads/other", "refs/tags/v1.0", "master"} {
+		status := m.handlePush("", pushEvent{Ref: ref, After: "aaa"})
+		if status.Accepted {
+			t.Errorf("push to %s was accepted", ref)
+		}
+	}
+	deleted := m.handlePush("", pushEvent{Ref: "refs/heads/master", After: zeroHash})
+	if deleted.Accepted {
+		t.Error("branch deletion was accepted")
+	}
+
+	untracked, _ := newHookManager("aaa")
+	untracked.trackBranch = ""
+	if status := untracked.handlePush("", pushEvent{Ref: "refs/heads/master", After: "aaa"}); status.Accepted {
+		t.Error("push was accepted with no tracked branch")
+	}
+	if len(m.hookQueue)+len(untracked.hookQueue) != 0 {
+		t.Error("rejected pushes were queued")
+	}
+}
+
+func TestWebhookOutOfOrder(t *testing.T) {
+	m, cm := newHookManager("aaa", "bbb", "ccc")
+	m.setRev("aaa")
+
+	// C arrives before B.
+	c := m.handlePush("2", pushEvent{Ref: "refs/heads/master", Before: "bbb", After: "ccc"})
+	if !c.Accepted || !c.OutOfOrder {
+		t.Errorf("push of C: %+v", c)
+	}
+	b := m.handlePush("1", pushEvent{Ref: "refs/heads/master", Before: "aaa", After: "bbb"})
+	if !b.Accepted || !b.OutOfOrder {
+		t.Errorf("push of B: %+v", b)
+	}
+
+	// A repeat of a head that was already pushed is stale.
+	again := m.handlePush("3", pushEvent{Ref: "refs/heads/master", Before: "bbb", After: "ccc"})
+	if again.Accepted {
+		t.Errorf("stale push was accepted: %+v", again)
+	}
+
+	close(m.hookQueue)
+	m.syncHooks()
+	if m.Rev() != "ccc" {
+		t.Errorf("graph is at %s, want ccc", m.Rev())
+	}
+	if len(cm.checkouts) != 1 || cm.checkouts[0] != "ccc" {
+		t.Errorf("checked out %v, want only ccc", cm.checkouts)
+	}
+}
+
+func TestWebhookSeenHeadsBounded(t *testing.T) {
+	m, _ := newHookManager()
+	m.hookMutex.Lock()
+	for i := 0; i < maxSeenHeads*2; i++ {
+		m.seeHead(fmt.Sprintf("rev-%d", i))
+	}
+	m.hookMutex.Unlock()
+	if len(m.seenHeads) != maxSeenHeads || len(m.seenOrder) != maxSeenHeads {
+		t.Errorf("remembered %d heads, want %d", len(m.seenHeads), maxSeenHeads)
+	}
+	if _, ok := m.seenHeads[fmt.Sprintf("rev-%d", maxSeenHeads*2-1)]; !ok {
+		t.Error("newest head was forgotten")
+	}
+}
@@ -0,0 +1,50 @@
+package reciever
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// NewAPIClient creates a new API client for a remote reciever.
+func NewAPIClient(l hclog.Logger, url string) (*APIClient, error) {
+	x := APIClient{
+		l:       l.Named("client"),
+		hClient: &http.Client{Timeout: 5 * time.Minute},
+		url:     url,
+	}
+	if x.url == "" {
+		x.l.Warn("URL must not be empty!")
+		return nil, errors.New("url must be set")
+	}
+	return &x, nil
+}
+
+// Remove asks the reciever to delete the listed packages, given as
+// pkgvers, from a repo and to drop them from the index.
+func (c *APIClient) Remove(arch, repo string, pkgvers []string) error {
+	body, err := json.Marshal(RemoveRequest{Arch: arch, Repo: repo, Pkgs: pkgvers})
+	if err != nil {
+		return err
+	}
+	resp, err := c.hClient.Post(c.url+"/remove", "application/json", bytes.NewReader(body))
+	if err != nil {
+		c.l.Warn("Unable to reach reciever", "err", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		errText := make(map[string]string)
+		b, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(b, &errText)
+		c.l.Warn("Error removing packages", "arch", arch, "repo", repo, "err", errText["Error"])
+		return errors.New("error removing packages")
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package reciever
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"os"
@@ -93,10 +94,50 @@ func (r *Reciever) handleFile(fname string, repo string, data io.ReadCloser) err
 	return nil
 }
 
+// removeFiles deletes packages from a repo and then cleans the index
+// of the packages whose files are gone.
+func (r *Reciever) removeFiles(arch, repo string, pkgvers []string) error {
+	if arch == "" || strings.ContainsAny(arch, "/.") || strings.Contains(repo, "..") {
+		return errors.New("invalid arch or repo")
+	}
+	dir := filepath.Join(r.path, arch, repo)
+
+	r.repoMutex.Lock()
+	defer r.repoMutex.Unlock()
+	for _, pkgver := range pkgvers {
+		if strings.ContainsAny(pkgver, "/*?[") {
+			r.l.Warn("Refusing to remove invalid package", "pkgver", pkgver)
+			continue
+		}
+		// The arch in the filename may be noarch.
+		files, _ := filepath.Glob(filepath.Join(dir, pkgver+".*.xbps"))
+		for _, f := range files {
+			for _, rm := range []string{f, f + ".sig", f + ".sig2"} {
+				if err := os.Remove(rm); err != nil && !os.IsNotExist(err) {
+					r.l.Warn("Unable to remove package file", "path", rm, "err", err)
+					return err
+				}
+			}
+			r.l.Info("Removed package", "path", f)
+		}
+	}
+
+	cmd := exec.Command("xbps-rindex", "-c", dir)
+	cmd.Env = append(os.Environ(),
+		"XBPS_TARGET_ARCH="+arch,
+	)
+	if err := cmd.Run(); err != nil {
+		r.l.Warn("Unable to clean index", "path", dir, "arch", arch, "err", err)
+		return err
+	}
+	return nil
+}
+
 // HTTPEntry provides the chi mountpoint for the reciever into the routing tree.
 func (r *Reciever) HTTPEntry() chi.Router {
 	rout := chi.NewRouter()
 	rout.Put("/file", r.httpFile)
+	rout.Post("/remove", r.httpRemove)
 	return rout
 }
 
@@ -110,6 +151,20 @@ func (r *Reciever) httpFile(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// httpRemove handles a request to remove packages from a repo.
+func (r *Reciever) httpRemove(w http.ResponseWriter, req *http.Request) {
+	rr := RemoveRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&rr); err != nil {
+		r.httpJSONError(w, err)
+		return
+	}
+	if err := r.removeFiles(rr.Arch, rr.Repo, rr.Pkgs); err != nil {
+		r.httpJSONError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 // jsonError returns a error as JSON.
 func (r *Reciever) httpJSONError(w http.ResponseWriter, err error) {
 	enc := json.NewEncoder(w)
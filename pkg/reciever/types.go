@@ -1,6 +1,7 @@
 package reciever
 
 import (
+	"net/http"
 	"sync"
 
 	"github.com/hashicorp/go-hclog"
@@ -13,3 +14,18 @@ type Reciever struct {
 	path      string
 	repoMutex *sync.Mutex
 }
+
+// APIClient talks to a remote reciever.
+type APIClient struct {
+	l       hclog.Logger
+	hClient *http.Client
+	url     string
+}
+
+// RemoveRequest lists packages, given as pkgvers, to be removed from
+// a repo.
+type RemoveRequest struct {
+	Arch string
+	Repo string
+	Pkgs []string
+}
@@ -0,0 +1,127 @@
+package repo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// fetchHTTP retrieves a repodata file.  When validators from an
+// earlier response are known a conditional request is made, and nil
+// is returned if the repodata has not changed and is already loaded.
+// Responses are stored in the cache directory, if there is one, so
+// that they survive a restart.
+func (i *Index) fetchHTTP(repo, path string, st *RepoStats, loaded bool) ([]byte, error) {
+	cached := i.readCache(path, st)
+
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil || loaded {
+		if st.ETag != "" {
+			req.Header.Set("If-None-Match", st.ETag)
+		}
+		if st.LastModified != "" {
+			req.Header.Set("If-Modified-Since", st.LastModified)
+		}
+	}
+
+	resp, err := i.hClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		if loaded {
+			return nil, nil
+		}
+		if cached == nil {
+			return nil, errors.New("not modified, but no cached repodata")
+		}
+		i.l.Debug("Using cached repodata", "repo", repo)
+		return cached, nil
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, errNotFound
+	default:
+		return nil, errors.New("unexpected status: " + resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	st.ETag = resp.Header.Get("ETag")
+	st.LastModified = resp.Header.Get("Last-Modified")
+	i.writeCache(path, body, st)
+	return body, nil
+}
+
+// cacheMeta is stored next to each cached repodata file.
+type cacheMeta struct {
+	URL          string
+	ETag         string
+	LastModified string
+}
+
+// cachePath returns the path of the cached copy of a repodata file.
+func (i *Index) cachePath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(i.cacheDir, i.Arch, hex.EncodeToString(sum[:8]))
+}
+
+// readCache returns the cached copy of a repodata file, if there is
+// one, and fills in the validators from it if none are known yet.
+func (i *Index) readCache(url string, st *RepoStats) []byte {
+	if i.cacheDir == "" {
+		return nil
+	}
+	p := i.cachePath(url)
+	metaBytes, err := os.ReadFile(p + ".json")
+	if err != nil {
+		return nil
+	}
+	meta := cacheMeta{}
+	if err := json.Unmarshal(metaBytes, &meta); err != nil || meta.URL != url {
+		return nil
+	}
+	body, err := os.ReadFile(p)
+	if err != nil {
+		return nil
+	}
+	if st.ETag == "" && st.LastModified == "" {
+		st.ETag = meta.ETag
+		st.LastModified = meta.LastModified
+	}
+	return body
+}
+
+// writeCache stores a repodata file and its validators.
+func (i *Index) writeCache(url string, body []byte, st *RepoStats) {
+	if i.cacheDir == "" {
+		return
+	}
+	p := i.cachePath(url)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		i.l.Warn("Unable to create repodata cache", "error", err)
+		return
+	}
+	metaBytes, _ := json.Marshal(cacheMeta{URL: url, ETag: st.ETag, LastModified: st.LastModified})
+	// Write the data before the metadata so that a crash in
+	// between never pairs old validators with new data.
+	if err := os.WriteFile(p, body, 0644); err != nil {
+		i.l.Warn("Unable to write repodata cache", "error", err)
+		return
+	}
+	if err := os.WriteFile(p+".json", metaBytes, 0644); err != nil {
+		i.l.Warn("Unable to write repodata cache", "error", err)
+	}
+}
@@ -0,0 +1,248 @@
+package repo
+
+import (
+	"archive/tar"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/klauspost/compress/zstd"
+)
+
+// repodata builds a repodata file from the files of its archive.
+func repodata(t *testing.T, files map[string]string) []byte {
+	b := &bytes.Buffer{}
+	z, err := zstd.NewWriter(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(z)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	z.Close()
+	return b.Bytes()
+}
+
+// indexPlist returns an index.plist that holds a single package.
+func indexPlist(pkgver string) string {
+	name := pkgver[:strings.LastIndex(pkgver, "-")]
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict><key>` + name + `</key><dict>
+<key>pkgver</key><string>` + pkgver + `</string>
+</dict></dict></plist>`
+}
+
+// mirror is a stand-in for a repo mirror that answers conditional
+// requests with either an ETag or a Last-Modified date.
+type mirror struct {
+	mu       sync.Mutex
+	data     []byte
+	etag     string
+	modified string
+
+	// conditional holds the validators sent with each request
+	// for the repodata.
+	conditional []string
+}
+
+func (m *mirror) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r.URL.Path != "/x86_64-repodata" {
+		// Nothing is staged.
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	m.conditional = append(m.conditional, r.Header.Get("If-None-Match")+r.Header.Get("If-Modified-Since"))
+
+	if m.etag != "" && r.Header.Get("If-None-Match") == m.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if m.modified != "" && r.Header.Get("If-Modified-Since") == m.modified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if m.etag != "" {
+		w.Header().Set("ETag", m.etag)
+	}
+	if m.modified != "" {
+		w.Header().Set("Last-Modified", m.modified)
+	}
+	w.Write(m.data)
+}
+
+func (m *mirror) set(data []byte, etag, modified string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data, m.etag, m.modified = data, etag, modified
+}
+
+func (m *mirror) lastConditional() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.conditional[len(m.conditional)-1]
+}
+
+func newMirror(t *testing.T, m *mirror) string {
+	srv := httptest.NewServer(m)
+	t.Cleanup(srv.Close)
+	return srv.URL + "/x86_64-repodata"
+}
+
+func newIndexService(cacheDir string) *IndexService {
+	is := NewIndexService(hclog.NewNullLogger())
+	is.SetCacheDir(cacheDir)
+	return is
+}
+
+func wantVersion(t *testing.T, is *IndexService, name, pkgver string) {
+	t.Helper()
+	e, err := is.GetPackage("x86_64", name)
+	if err != nil {
+		t.Fatalf("GetPackage(%s): %v", name, err)
+	}
+	if e.Pkgver != pkgver {
+		t.Errorf("%s is at %s, want %s", name, e.Pkgver, pkgver)
+	}
+}
+
+func wantStats(t *testing.T, is *IndexService, hits, misses int) {
+	t.Helper()
+	st := is.Stats()["x86_64"]["main"]
+	if st.Hits != hits || st.Misses != misses {
+		t.Errorf("got %d hits and %d misses, want %d and %d", st.Hits, st.Misses, hits, misses)
+	}
+}
+
+func TestCacheETag(t *testing.T) {
+	m := &mirror{}
+	m.set(repodata(t, map[string]string{"index.plist": indexPlist("foo-1.0_1")}), `"v1"`, "")
+	url := newMirror(t, m)
+
+	is := newIndexService(t.TempDir())
+	if err := is.LoadIndex("x86_64", "main", url); err != nil {
+		t.Fatal(err)
+	}
+	if err := is.ReloadArch("x86_64"); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.lastConditional(); got != `"v1"` {
+		t.Errorf("reload sent %q, want the ETag", got)
+	}
+	wantVersion(t, is, "foo", "foo-1.0_1")
+	wantStats(t, is, 1, 1)
+
+	m.set(repodata(t, map[string]string{"index.plist": indexPlist("foo-2.0_1")}), `"v2"`, "")
+	if err := is.ReloadArch("x86_64"); err != nil {
+		t.Fatal(err)
+	}
+	wantVersion(t, is, "foo", "foo-2.0_1")
+	wantStats(t, is, 1, 2)
+	if st := is.Stats()["x86_64"]["main"]; st.ETag != `"v2"` {
+		t.Errorf("ETag is %s, want \"v2\"", st.ETag)
+	}
+}
+
+func TestCacheLastModified(t *testing.T) {
+	const modified = "Mon, 02 Jan 2006 15:04:05 GMT"
+	m := &mirror{}
+	m.set(repodata(t, map[string]string{"index.plist": indexPlist("foo-1.0_1")}), "", modified)
+	url := newMirror(t, m)
+
+	is := newIndexService(t.TempDir())
+	if err := is.LoadIndex("x86_64", "main", url); err != nil {
+		t.Fatal(err)
+	}
+	if err := is.ReloadArch("x86_64"); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.lastConditional(); got != modified {
+		t.Errorf("reload sent %q, want the Last-Modified date", got)
+	}
+	wantStats(t, is, 1, 1)
+}
+
+func TestCacheUnchangedBody(t *testing.T) {
+	// A mirror without validators sends the whole file, which
+	// is not parsed again if it has not changed.
+	m := &mirror{}
+	m.set(repodata(t, map[string]string{"index.plist": indexPlist("foo-1.0_1")}), "", "")
+	url := newMirror(t, m)
+
+	is := newIndexService("")
+	if err := is.LoadIndex("x86_64", "main", url); err != nil {
+		t.Fatal(err)
+	}
+	if err := is.ReloadArch("x86_64"); err != nil {
+		t.Fatal(err)
+	}
+	wantStats(t, is, 1, 1)
+}
+
+func TestCacheRestart(t *testing.T) {
+	m := &mirror{}
+	m.set(repodata(t, map[string]string{"index.plist": indexPlist("foo-1.0_1")}), `"v1"`, "")
+	url := newMirror(t, m)
+	dir := t.TempDir()
+
+	if err := newIndexService(dir).LoadIndex("x86_64", "main", url); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new service starts with the validators and data from
+	// the cache, so the mirror only has to confirm them.
+	is := newIndexService(dir)
+	if err := is.LoadIndex("x86_64", "main", url); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.lastConditional(); got != `"v1"` {
+		t.Errorf("restart sent %q, want the cached ETag", got)
+	}
+	wantVersion(t, is, "foo", "foo-1.0_1")
+	wantStats(t, is, 0, 1)
+
+	// Without a cache the full file is requested.
+	is = newIndexService("")
+	if err := is.LoadIndex("x86_64", "main", url); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.lastConditional(); got != "" {
+		t.Errorf("uncached load sent %q", got)
+	}
+}
+
+func TestCacheMirrorChange(t *testing.T) {
+	data := repodata(t, map[string]string{"index.plist": indexPlist("foo-1.0_1")})
+	a, b := &mirror{}, &mirror{}
+	a.set(data, `"a"`, "")
+	b.set(data, `"b"`, "")
+	urlA, urlB := newMirror(t, a), newMirror(t, b)
+
+	is := newIndexService(t.TempDir())
+	if err := is.LoadIndex("x86_64", "main", urlA); err != nil {
+		t.Fatal(err)
+	}
+	if err := is.LoadIndex("x86_64", "main", urlB); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.lastConditional(); got != "" {
+		t.Errorf("the validators of one mirror were sent to another: %q", got)
+	}
+
+	// The content is the same, so it is not parsed again.
+	st := is.Stats()["x86_64"]["main"]
+	if st.URL != urlB || st.ETag != `"b"` {
+		t.Errorf("stats are for %s with ETag %s", st.URL, st.ETag)
+	}
+	wantStats(t, is, 1, 1)
+}
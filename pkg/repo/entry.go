@@ -0,0 +1,153 @@
+package repo
+
+import (
+	"sort"
+
+	"github.com/the-maldridge/nbuild/pkg/version"
+)
+
+// An Entry is a single binary package in a repository index.
+type Entry struct {
+	// Name, Repo and Staged are not part of the index entry
+	// itself, they are filled in from where the entry was found.
+	Name   string `plist:"-"`
+	Repo   string `plist:"-"`
+	Staged bool   `plist:"-"`
+
+	Pkgver    string `plist:"pkgver"`
+	BuildDate string `plist:"build-date"`
+
+	RunDepends    []string `plist:"run_depends"`
+	ShlibProvides []string `plist:"shlib-provides"`
+	ShlibRequires []string `plist:"shlib-requires"`
+	Provides      []string `plist:"provides"`
+	Replaces      []string `plist:"replaces"`
+
+	FilenameSHA256 string `plist:"filename-sha256"`
+	FilenameSize   int64  `plist:"filename-size"`
+}
+
+// Version returns the version of the entry without the package name.
+func (e *Entry) Version() string {
+	v, _ := version.PkgVersion(e.Pkgver)
+	return v
+}
+
+// A Query selects entries from an index.  Every field that is set
+// must match for an entry to be selected, and an empty query selects
+// every entry.
+type Query struct {
+	// Repo limits the query to a single repo.
+	Repo string
+
+	// Name is the exact name of the package.
+	Name string
+
+	// Provides is a pattern that one of the virtual packages the
+	// entry provides must match.
+	Provides string
+
+	// ShlibProvides and ShlibRequires are sonames such as
+	// libc.so.6.
+	ShlibProvides string
+	ShlibRequires string
+
+	// RunDepends and Replaces are package names that one of the
+	// patterns of the entry refers to.
+	RunDepends string
+	Replaces   string
+
+	// SHA256 is the hash of the binary package file.
+	SHA256 string
+
+	// BuildDate is the exact build date as recorded in the index,
+	// and FilenameSize is the size of the binary package file.
+	// A size of zero matches any size.
+	BuildDate    string
+	FilenameSize int64
+}
+
+// Matches reports whether the entry is selected by the query.
+func (q Query) Matches(e *Entry) bool {
+	switch {
+	case q.Repo != "" && q.Repo != e.Repo:
+		return false
+	case q.Name != "" && q.Name != e.Name:
+		return false
+	case q.SHA256 != "" && q.SHA256 != e.FilenameSHA256:
+		return false
+	case q.BuildDate != "" && q.BuildDate != e.BuildDate:
+		return false
+	case q.FilenameSize != 0 && q.FilenameSize != e.FilenameSize:
+		return false
+	case q.ShlibProvides != "" && !contains(e.ShlibProvides, q.ShlibProvides):
+		return false
+	case q.ShlibRequires != "" && !contains(e.ShlibRequires, q.ShlibRequires):
+		return false
+	case q.RunDepends != "" && !refersTo(e.RunDepends, q.RunDepends):
+		return false
+	case q.Replaces != "" && !refersTo(e.Replaces, q.Replaces):
+		return false
+	}
+	if q.Provides != "" {
+		for _, p := range e.Provides {
+			if version.Match(p, q.Provides) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Query returns the entries of an arch that match the query, sorted
+// by repo and name.
+func (is *IndexService) Query(arch string, q Query) ([]*Entry, error) {
+	idx, err := is.index(arch)
+	if err != nil {
+		return nil, err
+	}
+	return idx.Query(q), nil
+}
+
+// Query returns the entries of the index that match the query,
+// sorted by repo and name.
+func (i *Index) Query(q Query) []*Entry {
+	i.mu.Lock()
+	out := []*Entry{}
+	for _, pkgs := range i.merged() {
+		for _, e := range pkgs {
+			if q.Matches(e) {
+				out = append(out, e)
+			}
+		}
+	}
+	i.mu.Unlock()
+
+	sort.Slice(out, func(a, b int) bool {
+		if out[a].Repo != out[b].Repo {
+			return out[a].Repo < out[b].Repo
+		}
+		return out[a].Name < out[b].Name
+	})
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// refersTo reports whether any of the patterns names the package.
+func refersTo(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if version.PatternName(p) == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package repo
+
+import (
+	"testing"
+)
+
+func TestQueryMatches(t *testing.T) {
+	e := &Entry{
+		Name:         "foo",
+		Repo:         "main",
+		Pkgver:       "foo-1.0_1",
+		BuildDate:    "2024-01-02 03:04 UTC",
+		RunDepends:   []string{"bar>=2.0_1", "baz-1.0_1", "qux-1.[0-9]*"},
+		Provides:     []string{"foo-any-1.0_1"},
+		FilenameSize: 1234,
+	}
+
+	cases := []struct {
+		q    Query
+		want bool
+	}{
+		{Query{}, true},
+		{Query{Name: "foo"}, true},
+		{Query{Name: "bar"}, false},
+		{Query{BuildDate: "2024-01-02 03:04 UTC"}, true},
+		{Query{BuildDate: "2024-01-02"}, false},
+		{Query{FilenameSize: 1234}, true},
+		{Query{FilenameSize: 1235}, false},
+		{Query{RunDepends: "bar"}, true},
+		{Query{RunDepends: "baz"}, true},
+		{Query{RunDepends: "qux"}, true},
+		{Query{RunDepends: "qux-1"}, false},
+		{Query{Provides: "foo-any>=1.0"}, true},
+		{Query{Name: "foo", FilenameSize: 1}, false},
+	}
+	for _, c := range cases {
+		if got := c.q.Matches(e); got != c.want {
+			t.Errorf("%+v: got %v, want %v", c.q, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package repo
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Mirrors that fail are tried last until their backoff has passed.
+// The backoff doubles with each consecutive failure.
+const (
+	mirrorBackoff    = 30 * time.Second
+	mirrorMaxBackoff = 30 * time.Minute
+)
+
+// ErrStale is returned when repodata has not been refreshed recently
+// enough to be relied upon.
+var ErrStale = errors.New("repodata is stale")
+
+// Mirrors is an ordered list of the URLs that a repodata file can be
+// loaded from.  In JSON it may be given as a single string.
+type Mirrors []string
+
+// UnmarshalJSON accepts either a string or a list of strings.
+func (m *Mirrors) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*m = Mirrors{s}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*m = Mirrors(l)
+	return nil
+}
+
+// MirrorStatus reports the health of a single mirror of a repo.
+type MirrorStatus struct {
+	URL     string
+	Healthy bool
+
+	// Failures counts the consecutive failures of the mirror,
+	// which is tried last until RetryAfter.
+	Failures   int
+	RetryAfter time.Time
+	LastError  string `json:",omitempty"`
+
+	LastAttempt time.Time
+	LastSuccess time.Time
+}
+
+// Mirrors returns the status of the mirrors of every repo, keyed
+// first by arch and then by repo.
+func (is *IndexService) Mirrors() map[string]map[string][]MirrorStatus {
+	is.mu.Lock()
+	indicies := make([]*Index, 0, len(is.indicies))
+	for _, idx := range is.indicies {
+		indicies = append(indicies, idx)
+	}
+	is.mu.Unlock()
+
+	out := make(map[string]map[string][]MirrorStatus, len(indicies))
+	for _, idx := range indicies {
+		out[idx.Arch] = idx.Mirrors()
+	}
+	return out
+}
+
+// CheckFresh returns ErrStale if any repo of an arch has not been
+// loaded from one of its mirrors within maxAge.
+func (is *IndexService) CheckFresh(arch string, maxAge time.Duration) error {
+	idx, err := is.index(arch)
+	if err != nil {
+		return err
+	}
+	return idx.CheckFresh(maxAge)
+}
+
+// Mirrors returns the status of the mirrors of each repo.
+func (i *Index) Mirrors() map[string][]MirrorStatus {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	out := make(map[string][]MirrorStatus, len(i.mirrors))
+	for repo, mirrors := range i.mirrors {
+		for _, ms := range mirrors {
+			out[repo] = append(out[repo], *ms)
+		}
+	}
+	return out
+}
+
+// CheckFresh returns ErrStale if any repo has not been loaded from
+// one of its mirrors within maxAge.
+func (i *Index) CheckFresh(maxAge time.Duration) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for repo := range i.Repodatas {
+		var last time.Time
+		for _, ms := range i.mirrors[repo] {
+			if ms.LastSuccess.After(last) {
+				last = ms.LastSuccess
+			}
+		}
+		if time.Since(last) > maxAge {
+			i.l.Warn("Repodata is stale", "repo", repo, "loaded", last)
+			return ErrStale
+		}
+	}
+	return nil
+}
+
+// mirrorOrder returns the mirrors of a repo in the order to try them.
+// Mirrors keep their configured order, except that those still
+// backing off go last, soonest retry first.
+func (i *Index) mirrorOrder(repo string, mirrors []string) []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	known := make(map[string]*MirrorStatus, len(i.mirrors[repo]))
+	for _, ms := range i.mirrors[repo] {
+		known[ms.URL] = ms
+	}
+	statuses := make([]*MirrorStatus, len(mirrors))
+	for n, url := range mirrors {
+		ms, ok := known[url]
+		if !ok {
+			ms = &MirrorStatus{URL: url, Healthy: true}
+		}
+		statuses[n] = ms
+	}
+	i.mirrors[repo] = statuses
+
+	now := time.Now()
+	ready := []string{}
+	waiting := []*MirrorStatus{}
+	for _, ms := range statuses {
+		if ms.RetryAfter.After(now) {
+			waiting = append(waiting, ms)
+			continue
+		}
+		ready = append(ready, ms.URL)
+	}
+	sort.SliceStable(waiting, func(a, b int) bool {
+		return waiting[a].RetryAfter.Before(waiting[b].RetryAfter)
+	})
+	for _, ms := range waiting {
+		ready = append(ready, ms.URL)
+	}
+	return ready
+}
+
+// mirrorUp records a successful load from a mirror.
+func (i *Index) mirrorUp(repo, url string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	ms := i.mirror(repo, url)
+	if ms == nil {
+		return
+	}
+	if !ms.Healthy {
+		i.l.Info("Mirror recovered", "repo", repo, "mirror", url)
+	}
+	ms.Healthy = true
+	ms.Failures = 0
+	ms.RetryAfter = time.Time{}
+	ms.LastError = ""
+	ms.LastAttempt = time.Now()
+	ms.LastSuccess = ms.LastAttempt
+}
+
+// mirrorDown records a failed load from a mirror and backs it off.
+func (i *Index) mirrorDown(repo, url string, err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	ms := i.mirror(repo, url)
+	if ms == nil {
+		return
+	}
+	ms.Healthy = false
+	ms.Failures++
+	ms.LastError = err.Error()
+	ms.LastAttempt = time.Now()
+
+	backoff := mirrorBackoff
+	for n := 1; n < ms.Failures && backoff < mirrorMaxBackoff; n++ {
+		backoff *= 2
+	}
+	if backoff > mirrorMaxBackoff {
+		backoff = mirrorMaxBackoff
+	}
+	ms.RetryAfter = ms.LastAttempt.Add(backoff)
+}
+
+// mirror returns the status of a mirror.  The caller must hold mu.
+func (i *Index) mirror(repo, url string) *MirrorStatus {
+	for _, ms := range i.mirrors[repo] {
+		if ms.URL == url {
+			return ms
+		}
+	}
+	return nil
+}
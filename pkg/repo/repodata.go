@@ -3,17 +3,28 @@ package repo
 import (
 	"archive/tar"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"io"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/klauspost/compress/zstd"
 	"howett.net/plist"
+)
+
+// Errors returned when looking up packages.
+var (
+	ErrUnknownArch   = errors.New("arch is unknown")
+	ErrNoSuchPackage = errors.New("NoSuchPackage")
 
-	"github.com/the-maldridge/nbuild/pkg/types"
+	errNotFound = errors.New("repodata not found")
 )
 
 // IndexService is a wrapper around a lot of functions that
@@ -21,7 +32,12 @@ import (
 type IndexService struct {
 	l hclog.Logger
 
+	mu       *sync.Mutex
 	indicies map[string]*Index
+	cacheDir string
+
+	trusted  map[string]struct{}
+	unsigned map[string]struct{}
 }
 
 // Index is an architecture specific index.
@@ -29,108 +45,366 @@ type Index struct {
 	l hclog.Logger
 
 	Arch      string
-	Repodatas map[string]string
-	repos     map[string]map[string]*types.Package
+	Repodatas map[string][]string
+
+	hClient  *http.Client
+	cacheDir string
+	trusted  map[string]struct{}
+	unsigned map[string]struct{}
+
+	// mu guards repos, stage, status and mirrors, which are read
+	// while a reload is in progress.  stage holds the stagedata of
+	// each repo that has any.
+	mu      *sync.Mutex
+	repos   map[string]map[string]*Entry
+	stage   map[string]map[string]*Entry
+	status  map[string]*RepoStats
+	mirrors map[string][]*MirrorStatus
+}
+
+// RepoStats describes the state of a single repodata file.
+type RepoStats struct {
+	URL string
+
+	// LastCheck is when the repodata was last fetched or
+	// checked for changes, and LastReload is when it was last
+	// parsed.
+	LastCheck  time.Time
+	LastReload time.Time
+
+	// Hits counts the checks that found the repodata unchanged
+	// and so skipped parsing it, Misses counts those that had to
+	// parse it.
+	Hits   int
+	Misses int
+
+	// The validators from the last response, used to make
+	// conditional requests.
+	ETag         string
+	LastModified string
+	Sum          string
+
+	// Verification is one of the Verify constants.  Fingerprint
+	// and SignedBy describe the key of the repo, if it has one.
+	Verification string
+	Fingerprint  string `json:",omitempty"`
+	SignedBy     string `json:",omitempty"`
+
+	Error string `json:",omitempty"`
 }
 
 // NewIndexService creates an IndexService
 func NewIndexService(l hclog.Logger) *IndexService {
 	is := IndexService{
 		l:        l.Named("IndexService"),
+		mu:       new(sync.Mutex),
 		indicies: make(map[string]*Index),
 	}
 	return &is
 }
 
-// LoadIndex retrieves the index via http.
-func (is *IndexService) LoadIndex(arch, repo, path string) error {
+// SetCacheDir enables the on-disk cache of repodata files.  Indexes
+// that are already loaded are not affected.
+func (is *IndexService) SetCacheDir(dir string) {
+	is.cacheDir = dir
+}
+
+// LoadIndex retrieves the index of a repo from the first of its
+// mirrors that works.
+func (is *IndexService) LoadIndex(arch, repo string, mirrors ...string) error {
+	is.mu.Lock()
 	idx, ok := is.indicies[arch]
 	if !ok {
 		is.indicies[arch] = &Index{
 			l:         is.l.Named(arch),
 			Arch:      arch,
-			Repodatas: map[string]string{repo: path},
-			repos:     make(map[string]map[string]*types.Package),
+			Repodatas: make(map[string][]string),
+			hClient:   &http.Client{Timeout: 5 * time.Minute},
+			cacheDir:  is.cacheDir,
+			trusted:   is.trusted,
+			unsigned:  is.unsigned,
+			mu:        new(sync.Mutex),
+			repos:     make(map[string]map[string]*Entry),
+			stage:     make(map[string]map[string]*Entry),
+			status:    make(map[string]*RepoStats),
+			mirrors:   make(map[string][]*MirrorStatus),
 		}
 		idx = is.indicies[arch]
 	}
+	is.mu.Unlock()
 
-	if _, ok := idx.Repodatas[repo]; !ok {
-		idx.Repodatas[repo] = path
-	}
+	idx.mu.Lock()
+	idx.Repodatas[repo] = mirrors
+	idx.mu.Unlock()
 
-	return idx.Load(repo, path)
+	return idx.Load(repo, mirrors...)
 }
 
-// ReloadArch requests the specific arch to reload.
-func (is *IndexService) ReloadArch(arch string) error {
+// index returns the index of an arch.
+func (is *IndexService) index(arch string) (*Index, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
 	idx, ok := is.indicies[arch]
 	if !ok {
-		return errors.New("arch is unknown")
+		return nil, ErrUnknownArch
+	}
+	return idx, nil
+}
+
+// ReloadArch requests the specific arch to reload.
+func (is *IndexService) ReloadArch(arch string) error {
+	idx, err := is.index(arch)
+	if err != nil {
+		return err
 	}
 	return idx.ReloadAll()
 }
 
 // GetPackage returns a single package from a single arch if it is
 // known.
-func (is *IndexService) GetPackage(arch, pkg string) (*types.Package, error) {
-	idx, ok := is.indicies[arch]
-	if !ok {
-		return nil, errors.New("arch is unknown")
+func (is *IndexService) GetPackage(arch, pkg string) (*Entry, error) {
+	idx, err := is.index(arch)
+	if err != nil {
+		return nil, err
 	}
 	return idx.GetPackage(pkg)
 }
 
-// Load loads or reloads a single index from a file that is either on disk or remote.
-func (i *Index) Load(repo, path string) error {
-	var indexBytes []byte
+// Packages returns the packages of every repo of a single arch,
+// keyed first by repo and then by package name.  Staged packages take
+// the place of published ones.
+func (is *IndexService) Packages(arch string) (map[string]map[string]*Entry, error) {
+	idx, err := is.index(arch)
+	if err != nil {
+		return nil, err
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.merged(), nil
+}
+
+// Stats returns the state of every repodata file, keyed first by
+// arch and then by repo.
+func (is *IndexService) Stats() map[string]map[string]RepoStats {
+	is.mu.Lock()
+	indicies := make([]*Index, 0, len(is.indicies))
+	for _, idx := range is.indicies {
+		indicies = append(indicies, idx)
+	}
+	is.mu.Unlock()
+
+	out := make(map[string]map[string]RepoStats, len(indicies))
+	for _, idx := range indicies {
+		out[idx.Arch] = idx.Stats()
+	}
+	return out
+}
+
+// Load loads or reloads a single index from the first of its mirrors
+// that works.  Mirrors that have recently failed are tried last.
+func (i *Index) Load(repo string, mirrors ...string) error {
+	if len(mirrors) == 0 {
+		return errors.New("repo has no mirrors")
+	}
 	var err error
+	for _, m := range i.mirrorOrder(repo, mirrors) {
+		if err = i.loadMirror(repo, m); err == nil {
+			i.mirrorUp(repo, m)
+			return nil
+		}
+		i.mirrorDown(repo, m, err)
+		i.l.Warn("Mirror failed", "repo", repo, "mirror", m, "error", err)
+	}
+	return err
+}
+
+// loadMirror loads an index from a file that is either on disk or
+// remote, along with its stagedata if there is any.  If a file has
+// not changed since it was last loaded it is not parsed again.
+func (i *Index) loadMirror(repo, path string) error {
+	if err := i.load(repo, path, false); err != nil {
+		return err
+	}
+	if stage := stagePath(path); stage != "" {
+		return i.load(repo, stage, true)
+	}
+	return nil
+}
 
+// load loads the repodata or the stagedata of a repo.  Stagedata that
+// does not exist is not an error, it means that nothing is staged.
+func (i *Index) load(repo, path string, staged bool) error {
+	key := repo
+	if staged {
+		key = repo + stageSuffix
+	}
+	st := i.stats(key, path)
+	loaded := i.loaded(repo, staged)
+
+	var indexBytes []byte
+	var err error
 	switch {
 	case strings.HasPrefix(path, "http"):
-		indexBytes, err = i.fetchHTTP(path)
+		indexBytes, err = i.fetchHTTP(key, path, &st, loaded)
 	case strings.HasPrefix(path, "file"):
 		indexBytes, err = i.fetchFile(path)
 	default:
 		err = errors.New("unknown repodata scheme")
 		i.l.Error("Repodata scheme must be either file or http(s)")
 	}
+	st.LastCheck = time.Now()
+	if staged && err == errNotFound {
+		i.mu.Lock()
+		if _, ok := i.stage[repo]; ok {
+			i.l.Info("Stage is empty", "repo", repo)
+		}
+		delete(i.stage, repo)
+		delete(i.status, key)
+		i.mu.Unlock()
+		return nil
+	}
 	if err != nil {
 		i.l.Warn("Error loading arch", "error", err)
+		st.Error = err.Error()
+		i.setStats(key, st)
 		return err
 	}
+	st.Error = ""
+
+	if indexBytes == nil {
+		// The server says that nothing changed.
+		st.Hits++
+		i.setStats(key, st)
+		i.l.Trace("Repodata not modified", "repo", key)
+		return nil
+	}
+
+	sum := sha256.Sum256(indexBytes)
+	if hex.EncodeToString(sum[:]) == st.Sum && loaded {
+		st.Hits++
+		i.setStats(key, st)
+		i.l.Trace("Repodata unchanged", "repo", key)
+		return nil
+	}
 
-	return i.parseRepoData(repo, indexBytes)
+	pkgs, meta, err := i.parseRepoData(repo, indexBytes)
+	if err == nil {
+		err = i.verify(repo, meta, &st)
+	}
+	if err != nil {
+		i.l.Warn("Rejecting repodata", "repo", key, "error", err)
+		st.Error = err.Error()
+		i.setStats(key, st)
+		return err
+	}
+	st.Misses++
+	st.Sum = hex.EncodeToString(sum[:])
+	st.LastReload = time.Now()
+
+	i.mu.Lock()
+	if staged {
+		for _, e := range pkgs {
+			e.Staged = true
+		}
+		i.stage[repo] = pkgs
+	} else {
+		i.repos[repo] = pkgs
+	}
+	i.status[key] = &st
+	i.mu.Unlock()
+	return nil
 }
 
-// ReloadAll retrieves and re-loads all configured repodatas.
+// ReloadAll retrieves and re-loads all configured repodatas.  Every
+// repo is attempted, and the repos that could not be loaded from any
+// mirror are reported in the error.
 func (i *Index) ReloadAll() error {
-	for repo, path := range i.Repodatas {
-		i.Load(repo, path)
+	i.mu.Lock()
+	repodatas := make(map[string][]string, len(i.Repodatas))
+	for repo, mirrors := range i.Repodatas {
+		repodatas[repo] = mirrors
+	}
+	i.mu.Unlock()
+
+	failed := []string{}
+	for repo, mirrors := range repodatas {
+		if err := i.Load(repo, mirrors...); err != nil {
+			failed = append(failed, repo+": "+err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return errors.New("unable to reload " + strings.Join(failed, "; "))
 	}
 	return nil
 }
 
-func (i *Index) fetchHTTP(path string) ([]byte, error) {
-	resp, err := http.Get(path)
-	if err != nil {
-		return nil, err
+// Stats returns the state of each repodata file of the index.
+func (i *Index) Stats() map[string]RepoStats {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	out := make(map[string]RepoStats, len(i.status))
+	for repo, st := range i.status {
+		out[repo] = *st
 	}
+	return out
+}
 
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+// stats returns a copy of the state of a repo, which is updated with
+// setStats.  The validators of one mirror are not sent to another.
+func (i *Index) stats(repo, path string) RepoStats {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	st, ok := i.status[repo]
+	if !ok {
+		return RepoStats{URL: path}
+	}
+	out := *st
+	if out.URL != path {
+		out.URL = path
+		out.ETag = ""
+		out.LastModified = ""
+	}
+	return out
+}
+
+func (i *Index) setStats(repo string, st RepoStats) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.status[repo] = &st
+}
+
+// loaded reports whether the repodata or stagedata of a repo has
+// been parsed.
+func (i *Index) loaded(repo string, staged bool) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if staged {
+		_, ok := i.stage[repo]
+		return ok
 	}
-	return body, nil
+	_, ok := i.repos[repo]
+	return ok
 }
 
 func (i *Index) fetchFile(path string) ([]byte, error) {
-	return os.ReadFile(strings.TrimPrefix(path, "file://"))
+	b, err := os.ReadFile(strings.TrimPrefix(path, "file://"))
+	if os.IsNotExist(err) {
+		return nil, errNotFound
+	}
+	return b, err
 }
 
-// GetPackage returns a single package from the index.
-func (i *Index) GetPackage(name string) (*types.Package, error) {
+// GetPackage returns a single package from the index, preferring the
+// staged version if there is one.
+func (i *Index) GetPackage(name string) (*Entry, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, packages := range i.stage {
+		if pkg, ok := packages[name]; ok {
+			return pkg, nil
+		}
+	}
 	for _, packages := range i.repos {
 		pkg, ok := packages[name]
 		if !ok {
@@ -138,12 +412,12 @@ func (i *Index) GetPackage(name string) (*types.Package, error) {
 		}
 		return pkg, nil
 	}
-	return nil, errors.New("NoSuchPackage")
+	return nil, ErrNoSuchPackage
 }
 
 // Heavily inspired and simplified from the generalized reader in
 // Duncaen's go-xbps project.
-func (i *Index) parseRepoData(repo string, indexBytes []byte) error {
+func (i *Index) parseRepoData(repo string, indexBytes []byte) (map[string]*Entry, *indexMeta, error) {
 	i.l.Debug("Parsing repodata", "repo", repo)
 	ibr := bytes.NewReader(indexBytes)
 
@@ -151,7 +425,7 @@ func (i *Index) parseRepoData(repo string, indexBytes []byte) error {
 	// logic here to know what is being loaded.
 	d, err := zstd.NewReader(ibr)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	defer d.Close()
 
@@ -159,33 +433,51 @@ func (i *Index) parseRepoData(repo string, indexBytes []byte) error {
 
 	// Iterate throught the tar inside the zstd file and pick out
 	// the index list.  This contains the package graph that we're
-	// interested in.
+	// interested in.  The index meta holds the key of the repo,
+	// and is absent or empty if the repo is unsigned.
+	var pkgs map[string]*Entry
+	var meta *indexMeta
 	for {
 		header, err := tarchive.Next()
 		switch err {
 		case nil:
 		case io.EOF:
-			return nil
+			if pkgs == nil {
+				return nil, nil, errors.New("repodata has no index")
+			}
+			return pkgs, meta, nil
 		default:
-			return err
+			return nil, nil, err
 		}
 
-		if header.Name != "index.plist" {
+		if header.Name != "index.plist" && header.Name != "index-meta.plist" {
 			continue
 		}
 
 		buf := &bytes.Buffer{}
 		if _, err := buf.ReadFrom(tarchive); err != nil {
-			return err
+			return nil, nil, err
+		}
+		if header.Name == "index-meta.plist" {
+			if buf.Len() == 0 {
+				continue
+			}
+			meta = &indexMeta{}
+			if _, err := plist.Unmarshal(buf.Bytes(), meta); err != nil {
+				return nil, nil, err
+			}
+			continue
 		}
+
 		rs := bytes.NewReader(buf.Bytes())
 		dec := plist.NewDecoder(rs)
-		pkgs := make(map[string]*types.Package)
+		pkgs = make(map[string]*Entry)
 		if err := dec.Decode(pkgs); err != nil {
-			return err
+			return nil, nil, err
+		}
+		for name, e := range pkgs {
+			e.Name = name
+			e.Repo = repo
 		}
-		i.repos[repo] = pkgs
-		return nil
 	}
-	return nil
 }
@@ -0,0 +1,88 @@
+package repo
+
+import (
+	"sort"
+	"strings"
+)
+
+// stageSuffix is appended to the name of a repo to name its
+// stagedata.
+const stageSuffix = "-stagedata"
+
+// A StagedPackage is built and in the stagedata of a repo, but not
+// yet published in its repodata.  Published is the version in the
+// repodata, if any.
+type StagedPackage struct {
+	Repo      string
+	Name      string
+	Staged    string
+	Published string `json:",omitempty"`
+}
+
+// stagePath returns the path of the stagedata that goes with a
+// repodata file, or an empty string if the path does not follow the
+// naming of xbps.
+func stagePath(path string) string {
+	if !strings.HasSuffix(path, "-repodata") {
+		return ""
+	}
+	return strings.TrimSuffix(path, "-repodata") + stageSuffix
+}
+
+// Staged returns the packages of an arch that are staged but not yet
+// published, sorted by repo and name.
+func (is *IndexService) Staged(arch string) ([]StagedPackage, error) {
+	idx, err := is.index(arch)
+	if err != nil {
+		return nil, err
+	}
+	return idx.Staged(), nil
+}
+
+// Staged returns the packages of the index that are staged but not
+// yet published, sorted by repo and name.
+func (i *Index) Staged() []StagedPackage {
+	i.mu.Lock()
+	out := []StagedPackage{}
+	for repo, pkgs := range i.stage {
+		for name, e := range pkgs {
+			sp := StagedPackage{Repo: repo, Name: name, Staged: e.Version()}
+			if pub, ok := i.repos[repo][name]; ok {
+				if pub.Pkgver == e.Pkgver {
+					continue
+				}
+				sp.Published = pub.Version()
+			}
+			out = append(out, sp)
+		}
+	}
+	i.mu.Unlock()
+
+	sort.Slice(out, func(a, b int) bool {
+		if out[a].Repo != out[b].Repo {
+			return out[a].Repo < out[b].Repo
+		}
+		return out[a].Name < out[b].Name
+	})
+	return out
+}
+
+// merged returns the packages of each repo with the staged packages
+// taking the place of the published ones.  The caller must hold mu.
+func (i *Index) merged() map[string]map[string]*Entry {
+	out := make(map[string]map[string]*Entry, len(i.repos))
+	for repo, pkgs := range i.repos {
+		out[repo] = pkgs
+	}
+	for repo, staged := range i.stage {
+		pkgs := make(map[string]*Entry, len(out[repo])+len(staged))
+		for name, e := range out[repo] {
+			pkgs[name] = e
+		}
+		for name, e := range staged {
+			pkgs[name] = e
+		}
+		out[repo] = pkgs
+	}
+	return out
+}
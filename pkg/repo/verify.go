@@ -0,0 +1,131 @@
+package repo
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"strings"
+)
+
+// Verification states of a repo.
+const (
+	// VerifyDisabled repos were loaded without checking their
+	// key because no trusted keys are configured.
+	VerifyDisabled = "disabled"
+
+	// VerifyExempt repos are allowed to be unsigned.
+	VerifyExempt = "exempt"
+
+	// VerifyTrusted repos carry a trusted key.  Only the
+	// fingerprint of the key in index-meta.plist is checked, no
+	// signature over the repodata is verified.
+	VerifyTrusted = "trusted"
+
+	// VerifyUntrusted repos are signed with a key that is not
+	// trusted, and VerifyUnsigned repos are not signed at all.
+	// Both are rejected.
+	VerifyUntrusted = "untrusted"
+	VerifyUnsigned  = "unsigned"
+)
+
+var (
+	errUnsigned  = errors.New("repodata is not signed")
+	errUntrusted = errors.New("repodata is signed with an untrusted key")
+)
+
+// indexMeta is the index-meta.plist of a signed repo.
+type indexMeta struct {
+	PublicKey     []byte `plist:"public-key"`
+	PublicKeySize int    `plist:"public-key-size"`
+	SignatureBy   string `plist:"signature-by"`
+	SignatureType string `plist:"signature-type"`
+}
+
+// SetTrustedKeys enables verification of repodata.  Repos must then
+// carry one of the keys, given as fingerprints in the colon separated
+// form that xbps prints, unless they are named in unsigned.  Indexes
+// that are already loaded are not affected.
+func (is *IndexService) SetTrustedKeys(keys, unsigned []string) {
+	is.trusted = make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		is.trusted[normalizeFingerprint(k)] = struct{}{}
+	}
+	is.unsigned = make(map[string]struct{}, len(unsigned))
+	for _, r := range unsigned {
+		is.unsigned[r] = struct{}{}
+	}
+}
+
+// verify checks the key of a repo against the trusted keys and
+// records the result in st.  An error is returned if the repo must
+// be rejected.  A key that cannot be read only rejects the repo if
+// the key is needed, otherwise the error is recorded in st.
+//
+// xbps signs the packages in a repo rather than the repodata, so this
+// establishes that the repo belongs to a trusted signer, much as xbps
+// does when it asks to import a key.
+func (i *Index) verify(repo string, meta *indexMeta, st *RepoStats) error {
+	st.Fingerprint = ""
+	st.SignedBy = ""
+	var keyErr error
+	if meta != nil && len(meta.PublicKey) > 0 {
+		fp, err := fingerprint(meta.PublicKey)
+		if err != nil {
+			i.l.Warn("Unable to read repo key", "repo", repo, "error", err)
+			keyErr = err
+		}
+		st.Fingerprint = fp
+		st.SignedBy = meta.SignatureBy
+	}
+
+	if len(i.trusted) == 0 {
+		st.Verification = VerifyDisabled
+		recordKeyError(st, keyErr)
+		return nil
+	}
+	if _, ok := i.unsigned[repo]; ok {
+		st.Verification = VerifyExempt
+		recordKeyError(st, keyErr)
+		return nil
+	}
+	if keyErr != nil {
+		st.Verification = VerifyUntrusted
+		return keyErr
+	}
+	if st.Fingerprint == "" {
+		st.Verification = VerifyUnsigned
+		return errUnsigned
+	}
+	if _, ok := i.trusted[normalizeFingerprint(st.Fingerprint)]; !ok {
+		st.Verification = VerifyUntrusted
+		return errUntrusted
+	}
+	st.Verification = VerifyTrusted
+	return nil
+}
+
+func recordKeyError(st *RepoStats, err error) {
+	if err != nil {
+		st.Error = err.Error()
+	}
+}
+
+// fingerprint computes the fingerprint of a PEM encoded public key
+// the same way xbps does, as the MD5 sum of the key.
+func fingerprint(key []byte) (string, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return "", errors.New("public key is not PEM encoded")
+	}
+	sum := md5.Sum(block.Bytes)
+	parts := make([]string, len(sum))
+	for n, b := range sum {
+		parts[n] = hex.EncodeToString([]byte{b})
+	}
+	return strings.Join(parts, ":"), nil
+}
+
+func normalizeFingerprint(fp string) string {
+	return strings.ToLower(strings.ReplaceAll(fp, ":", ""))
+}
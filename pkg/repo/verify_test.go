@@ -0,0 +1,56 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const testKey = `-----BEGIN PUBLIC KEY-----
+AAAA
+-----END PUBLIC KEY-----
+`
+
+func TestVerify(t *testing.T) {
+	fp, err := fingerprint([]byte(testKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := &indexMeta{PublicKey: []byte(testKey), SignatureBy: "Void"}
+	malformed := &indexMeta{PublicKey: []byte("not a key")}
+
+	cases := []struct {
+		name    string
+		trusted []string
+		repo    string
+		meta    *indexMeta
+		want    string
+		wantErr bool
+	}{
+		{"disabled", nil, "main", nil, VerifyDisabled, false},
+		{"disabled malformed", nil, "main", malformed, VerifyDisabled, false},
+		{"trusted", []string{fp}, "main", signed, VerifyTrusted, false},
+		{"untrusted", []string{"00:11"}, "main", signed, VerifyUntrusted, true},
+		{"unsigned", []string{fp}, "main", nil, VerifyUnsigned, true},
+		{"malformed", []string{fp}, "main", malformed, VerifyUntrusted, true},
+		{"exempt", []string{fp}, "local", nil, VerifyExempt, false},
+		{"exempt malformed", []string{fp}, "local", malformed, VerifyExempt, false},
+	}
+	for _, c := range cases {
+		is := NewIndexService(hclog.NewNullLogger())
+		is.SetTrustedKeys(c.trusted, []string{"local"})
+		i := &Index{l: hclog.NewNullLogger(), trusted: is.trusted, unsigned: is.unsigned}
+
+		st := RepoStats{}
+		err := i.verify(c.repo, c.meta, &st)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: got error %v", c.name, err)
+		}
+		if st.Verification != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, st.Verification, c.want)
+		}
+		if c.meta == malformed && !c.wantErr && st.Error == "" {
+			t.Errorf("%s: key error was not recorded", c.name)
+		}
+	}
+}
@@ -34,3 +34,22 @@ func WithGraphURL(url string) Option {
 		return nil
 	}
 }
+
+// WithPriorities pins additional priority on the named packages.  The
+// value is added to the number of dirty packages that wait on the
+// package when ordering the queue.
+func WithPriorities(p map[string]int) Option {
+	return func(s *Scheduler) error {
+		s.priorities = p
+		return nil
+	}
+}
+
+// WithBuildTimePriority orders packages with equal priority by how
+// long they have previously taken to build, longest first.
+func WithBuildTimePriority(b bool) Option {
+	return func(s *Scheduler) error {
+		s.useBuildTime = b
+		return nil
+	}
+}
@@ -1,11 +1,16 @@
 package scheduler
 
 import (
+	"context"
 	"errors"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
+
+	"github.com/the-maldridge/nbuild/pkg/graph"
+	"github.com/the-maldridge/nbuild/pkg/types"
 )
 
 // NewScheduler returns a scheduler instance using the listed capacity
@@ -16,6 +21,8 @@ func NewScheduler(opts ...Option) (*Scheduler, error) {
 	x := &Scheduler{
 		l:          hclog.NewNullLogger(),
 		queueMutex: new(sync.Mutex),
+		started:    make(map[Build]time.Time),
+		buildTimes: make(map[string]time.Duration),
 	}
 
 	for _, o := range opts {
@@ -40,6 +47,7 @@ func (s *Scheduler) send() error {
 		return err
 	}
 	s.l.Trace("Dispatching", "build", s.queue[0])
+	s.started[s.queue[0]] = time.Now()
 	s.queue = s.queue[1:]
 	return nil
 }
@@ -60,6 +68,8 @@ func (s *Scheduler) Reconstruct() error {
 	if err != nil {
 		return err
 	}
+	s.trackBuildTimes(current)
+	s.tuples = make([]types.SpecTuple, 0, len(dispatchable.Pkgs))
 	for tuple, pkgs := range dispatchable.Pkgs {
 		for _, pkg := range pkgs {
 			b := Build{
@@ -80,13 +90,69 @@ func (s *Scheduler) Reconstruct() error {
 		}
 		s.tuples = append(s.tuples, tuple)
 	}
+	s.prioritize(dispatchable.Scores)
 	s.l.Info("Successfully reconstructed queue")
 	return nil
 }
 
+// prioritize orders the queue so that the builds that unblock the
+// most work are dispatched first.  The caller must hold queueMutex.
+func (s *Scheduler) prioritize(scores map[types.SpecTuple]map[string]int) {
+	score := func(b Build) int {
+		return scores[b.Spec][b.Pkg] + s.priorities[b.Pkg]
+	}
+	sort.SliceStable(s.queue, func(i, j int) bool {
+		a, b := s.queue[i], s.queue[j]
+		if score(a) != score(b) {
+			return score(a) > score(b)
+		}
+		if s.useBuildTime {
+			ta, tb := s.buildTimes[a.Spec.String()+"/"+a.Pkg], s.buildTimes[b.Spec.String()+"/"+b.Pkg]
+			if ta != tb {
+				return ta > tb
+			}
+		}
+		if a.Spec != b.Spec {
+			return a.Spec.String() < b.Spec.String()
+		}
+		return a.Pkg < b.Pkg
+	})
+}
+
+// trackBuildTimes records how long builds took by watching for them
+// to disappear from the capacity provider.  Builds that were already
+// running when the scheduler started are timed from when they were
+// first seen.  The caller must hold queueMutex.
+func (s *Scheduler) trackBuildTimes(current []Build) {
+	now := time.Now()
+	running := make(map[Build]struct{}, len(current))
+	for _, b := range current {
+		running[b] = struct{}{}
+		if _, ok := s.started[b]; !ok {
+			s.started[b] = now
+		}
+	}
+	for b, start := range s.started {
+		if _, ok := running[b]; ok {
+			continue
+		}
+		if now.Sub(start) < time.Second {
+			// Dispatched, but not yet visible to the
+			// provider.
+			continue
+		}
+		s.buildTimes[b.Spec.String()+"/"+b.Pkg] = now.Sub(start)
+		delete(s.started, b)
+	}
+}
+
 // Update graph and then queue.
 func (s *Scheduler) Update() error {
-	for _, tuple := range s.tuples {
+	s.queueMutex.Lock()
+	tuples := s.tuples
+	s.queueMutex.Unlock()
+
+	for _, tuple := range tuples {
 		if err := s.apiClient.Clean(tuple.Target); err != nil {
 			return err
 		}
@@ -100,6 +166,11 @@ func (s *Scheduler) Update() error {
 func (s *Scheduler) Run() {
 	s.Reconstruct() // Get tuples
 	s.Update()      // Now get real dispatchable
+	ctx, cancel := context.WithCancel(context.Background())
+	s.queueMutex.Lock()
+	s.cancel = cancel
+	s.queueMutex.Unlock()
+	go s.watch(ctx)
 	for !s.stop {
 		err := s.send()
 		if err != nil {
@@ -109,7 +180,33 @@ func (s *Scheduler) Run() {
 	}
 }
 
+// watch subscribes to the graph events and reconstructs the queue
+// whenever the dispatchable set changes, rather than waiting to
+// poll.  The subscription is retried if the stream is lost.
+func (s *Scheduler) watch(ctx context.Context) {
+	for ctx.Err() == nil {
+		events, err := s.apiClient.Subscribe(ctx, graph.EventDispatchable)
+		if err != nil {
+			s.l.Warn("Unable to watch graph events", "error", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+		for range events {
+			if err := s.Reconstruct(); err != nil {
+				s.l.Warn("Error reconstructing queue", "error", err)
+			}
+		}
+		time.Sleep(time.Second)
+	}
+}
+
 // Stop stops the scheduler
 func (s *Scheduler) Stop() {
 	s.stop = true
+	s.queueMutex.Lock()
+	cancel := s.cancel
+	s.queueMutex.Unlock()
+	if cancel != nil {
+		cancel()
+	}
 }
@@ -1,7 +1,9 @@
 package scheduler
 
 import (
+	"context"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 
@@ -34,8 +36,22 @@ type Scheduler struct {
 	apiClient        *graph.APIClient
 	capacityProvider CapacityProvider
 
+	// priorities are added to the score of the named packages.
+	priorities map[string]int
+
+	// When useBuildTime is set, packages that have historically
+	// taken longer to build are dispatched first among those with
+	// the same score.
+	useBuildTime bool
+	started      map[Build]time.Time
+	buildTimes   map[string]time.Duration
+
 	// Stop should be set true when the scheduler should stop
 	stop bool
+
+	// cancel ends the subscription to graph events.  It is
+	// guarded by queueMutex.
+	cancel context.CancelFunc
 }
 
 // Option provides a convenient way to pass in options to the system.
@@ -52,6 +52,28 @@ func (r *RepoMngr) At() (string, error) {
 	return head.Hash().String(), nil
 }
 
+// IsAncestor reports whether the commit ancestor is reachable from
+// the commit descendant.  A commit is its own ancestor.
+func (r *RepoMngr) IsAncestor(ancestor, descendant string) (bool, error) {
+	if r.repo == nil {
+		r.l.Warn("Error in repo manager, repo must be bootstrapped to walk history")
+	}
+	r.Mu.Lock()
+	defer r.Mu.Unlock()
+
+	a, err := r.repo.CommitObject(gitPlumbing.NewHash(ancestor))
+	if err != nil {
+		r.l.Trace("Error getting ancestor CommitObject", "err", err, "commit", ancestor)
+		return false, err
+	}
+	d, err := r.repo.CommitObject(gitPlumbing.NewHash(descendant))
+	if err != nil {
+		r.l.Trace("Error getting descendant CommitObject", "err", err, "commit", descendant)
+		return false, err
+	}
+	return a.IsAncestor(d)
+}
+
 // Checkout a particular revision
 func (r *RepoMngr) Checkout(commit string) ([]string, error) {
 	if r.repo == nil {
@@ -88,6 +110,10 @@ func (r *RepoMngr) Checkout(commit string) ([]string, error) {
 	}
 	newHash := gitPlumbing.NewHash(commit)
 	err = worktree.Checkout(&git.CheckoutOptions{Hash: newHash, Force: true})
+	if err != nil {
+		r.l.Warn("Error checking out worktree", "err", err, "path", r.Path)
+		return nil, err
+	}
 
 	// Diff the two commits
 	newCommit, err := r.repo.CommitObject(newHash)
@@ -120,9 +146,29 @@ func (r *RepoMngr) Fetch() error {
 	defer r.Mu.Unlock()
 	r.l.Debug("Fetching origin for git repository", "path", r.Path)
 	err := r.repo.Fetch(&git.FetchOptions{RemoteName: "origin"})
+	if err == git.NoErrAlreadyUpToDate {
+		r.l.Trace("Already up to date")
+		return nil
+	}
 	if err != nil {
 		r.l.Trace("Error fetching")
 		return err
 	}
 	return nil
 }
+
+// RemoteHead returns the hash that a branch on origin currently
+// points to as of the last fetch.
+func (r *RepoMngr) RemoteHead(branch string) (string, error) {
+	if r.repo == nil {
+		r.l.Warn("Error in repo manager, repo must be bootstrapped to resolve refs")
+	}
+	r.Mu.Lock()
+	defer r.Mu.Unlock()
+	ref, err := r.repo.Reference(gitPlumbing.NewRemoteReferenceName("origin", branch), true)
+	if err != nil {
+		r.l.Trace("Error resolving remote branch", "branch", branch, "err", err)
+		return "", err
+	}
+	return ref.Hash().String(), nil
+}
@@ -0,0 +1,23 @@
+package types
+
+import (
+	"path"
+	"strings"
+)
+
+// An ImpactRule assigns a scope to changed paths in the checkout
+// that match its pattern.  The scopes are defined by the graph.
+type ImpactRule struct {
+	Pattern string
+	Scope   string
+}
+
+// Matches reports whether the rule applies to a path relative to the
+// root of the checkout.  The pattern matches either the path itself,
+// as a glob, or any path beneath it.
+func (r ImpactRule) Matches(rel string) bool {
+	if m, err := path.Match(r.Pattern, rel); err == nil && m {
+		return true
+	}
+	return strings.HasPrefix(rel, strings.TrimSuffix(r.Pattern, "/")+"/")
+}
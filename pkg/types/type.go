@@ -11,6 +11,86 @@ type Package struct {
 	MakeDepends map[string]struct{}
 	Depends     map[string]struct{}
 	Subpackages map[string]struct{}
+
+	// These control which specs a package may be built for.
+	Archs      []string
+	NoCross    string
+	Broken     string
+	Restricted bool
+
+	// Buildable is computed for the spec of the graph that the
+	// package lives in.  When it is false BuildableReason
+	// explains why.
+	Buildable       bool
+	BuildableReason string
+
+	// RepoState compares the version in the repository index of
+	// the spec with the version of the template.
+	RepoState RepoState `plist:"-"`
+
+	// Publish tells whether the binary in the repository index
+	// of the spec is published or only staged.  It is empty if
+	// there is no binary.
+	Publish PublishState `plist:"-"`
+}
+
+// RepoState describes how the version of a package in a repository
+// index relates to the version of its template.
+type RepoState string
+
+const (
+	// RepoMissing packages are not in the index at all.
+	RepoMissing RepoState = "missing"
+
+	// RepoOlder packages are in the index at an older version
+	// than the template.
+	RepoOlder RepoState = "older"
+
+	// RepoEqual packages are in the index at the version of the
+	// template.
+	RepoEqual RepoState = "equal"
+
+	// RepoNewer packages are in the index at a newer version
+	// than the template, such as after a template is reverted.
+	RepoNewer RepoState = "newer"
+)
+
+// PublishState describes whether the binary of a package is
+// published in the repodata or is only in the stagedata.
+type PublishState string
+
+const (
+	// PublishStaged packages are built but held back in the
+	// stagedata until the rest of their update is built.
+	PublishStaged PublishState = "staged"
+
+	// PublishPublished packages are in the repodata.
+	PublishPublished PublishState = "published"
+)
+
+// DepKind identifies which of a package's dependency lists an edge
+// in the graph was taken from.
+type DepKind string
+
+const (
+	// DepHost is an edge from hostmakedepends.
+	DepHost DepKind = "host"
+
+	// DepMake is an edge from makedepends.
+	DepMake DepKind = "make"
+
+	// DepRun is an edge from depends.
+	DepRun DepKind = "run"
+)
+
+// DepsByKind returns the dependency lists of the package keyed by
+// the kind of edge they represent.
+func (p Package) DepsByKind() map[DepKind]map[string]struct{} {
+	return map[DepKind]map[string]struct{}{
+		DepHost: p.HostDepends,
+		DepMake: p.MakeDepends,
+		DepRun:  p.Depends,
+	}
 }
 
 func (p Package) String() string {
@@ -23,10 +103,18 @@ type Atom struct {
 	Pkgs    map[string]*Package
 	Virtual map[string]string
 
+	// Owners maps the name of each subpackage to the source
+	// package that provides it.
+	Owners map[string]string
+
 	// bad returned some errors, so we keep an eye on what the
 	// error was and continue.
 	Bad map[string]string
 
+	// Cycles lists the groups of packages that depend on each
+	// other and so can never be built by walking the graph.
+	Cycles [][]string
+
 	// These keep track of what the archs this graph is rendered
 	// from are.
 	Spec SpecTuple
@@ -34,4 +122,31 @@ type Atom struct {
 	// Rev stores the git revision of the PkgGraph for later so
 	// that we can tell if the graph needs to be reloaded.
 	Rev string
+
+	// SyncMode records how the graph was brought up to Rev.
+	SyncMode string
+}
+
+// Copy returns a deep copy of the package.
+func (p Package) Copy() *Package {
+	c := p
+	c.HostDepends = copySet(p.HostDepends)
+	c.MakeDepends = copySet(p.MakeDepends)
+	c.Depends = copySet(p.Depends)
+	c.Subpackages = copySet(p.Subpackages)
+	if p.Archs != nil {
+		c.Archs = append([]string{}, p.Archs...)
+	}
+	return &c
+}
+
+func copySet(m map[string]struct{}) map[string]struct{} {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]struct{}, len(m))
+	for k := range m {
+		c[k] = struct{}{}
+	}
+	return c
 }
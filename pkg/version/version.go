@@ -0,0 +1,386 @@
+// Package version implements xbps version comparison and package
+// pattern matching without calling out to any of the xbps tools.
+package version
+
+import (
+	"strconv"
+	"strings"
+)
+
+// operators separate the name of a package from the version
+// constraints in a pattern, and globs make a pattern match many
+// versions.
+const (
+	operators = "<>=~"
+	globs     = "*?[{"
+)
+
+// Component values for the modifiers that may appear within a
+// version.  These sort before a plain dot so that 1.0rc1 is older
+// than 1.0.
+const (
+	alpha = -3
+	beta  = -2
+	rc    = -1
+	dot   = 0
+)
+
+var modifiers = []struct {
+	s string
+	v int
+}{
+	{"alpha", alpha},
+	{"beta", beta},
+	{"pre", rc},
+	{"rc", rc},
+	{"pl", dot},
+	{".", dot},
+}
+
+// parsed is a version broken into its numeric components and its
+// revision.
+type parsed struct {
+	components []int
+	revision   int
+}
+
+// parse breaks a version into components in the same way as the
+// dewey comparison in libxbps.
+func parse(s string) parsed {
+	p := parsed{}
+	s = strings.ToLower(s)
+	for len(s) > 0 {
+		if s[0] == '_' {
+			s = s[1:]
+			n := digits(s)
+			p.revision, _ = strconv.Atoi(s[:n])
+			s = s[n:]
+			continue
+		}
+
+		if n := digits(s); n > 0 {
+			v, _ := strconv.Atoi(s[:n])
+			p.components = append(p.components, v)
+			s = s[n:]
+			continue
+		}
+
+		matched := false
+		for _, m := range modifiers {
+			if strings.HasPrefix(s, m.s) {
+				p.components = append(p.components, m.v)
+				s = s[len(m.s):]
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+
+		if s[0] >= 'a' && s[0] <= 'z' {
+			// A lone letter is treated as a sub-release,
+			// so 1.0a sorts after 1.0.
+			p.components = append(p.components, dot, int(s[0]-'a')+1)
+		}
+		s = s[1:]
+	}
+	return p
+}
+
+func digits(s string) int {
+	n := 0
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	return n
+}
+
+// Compare compares two versions of the form version_revision and
+// returns -1, 0, or 1 if a is older than, the same as, or newer than
+// b respectively.
+func Compare(a, b string) int {
+	pa, pb := parse(a), parse(b)
+
+	l := len(pa.components)
+	if len(pb.components) > l {
+		l = len(pb.components)
+	}
+	for i := 0; i < l; i++ {
+		ca, cb := 0, 0
+		if i < len(pa.components) {
+			ca = pa.components[i]
+		}
+		if i < len(pb.components) {
+			cb = pb.components[i]
+		}
+		if ca != cb {
+			return sign(ca - cb)
+		}
+	}
+	return sign(pa.revision - pb.revision)
+}
+
+func sign(i int) int {
+	switch {
+	case i < 0:
+		return -1
+	case i > 0:
+		return 1
+	}
+	return 0
+}
+
+// PkgName returns the name portion of a pkgver such as foo-1.0_1.
+// The second return value is false if the string is not a valid
+// pkgver.
+func PkgName(pkgver string) (string, bool) {
+	i := strings.LastIndex(pkgver, "-")
+	if i <= 0 || !validVersion(pkgver[i+1:]) {
+		return "", false
+	}
+	return pkgver[:i], true
+}
+
+// PkgVersion returns the version portion of a pkgver such as
+// foo-1.0_1.  The second return value is false if the string is not
+// a valid pkgver.
+func PkgVersion(pkgver string) (string, bool) {
+	i := strings.LastIndex(pkgver, "-")
+	if i <= 0 || !validVersion(pkgver[i+1:]) {
+		return "", false
+	}
+	return pkgver[i+1:], true
+}
+
+// validVersion checks that a version ends in a numeric revision and
+// is not itself part of a pattern.
+func validVersion(v string) bool {
+	if strings.ContainsAny(v, operators+globs+"]}") {
+		return false
+	}
+	i := strings.LastIndex(v, "_")
+	if i <= 0 || i == len(v)-1 {
+		return false
+	}
+	return digits(v[i+1:]) == len(v)-i-1
+}
+
+// PatternName returns the package name that a dependency pattern
+// refers to.  Constraints are split off first, since the name may
+// contain hyphens, then the version of a glob or an exact pkgver.
+func PatternName(pattern string) string {
+	if i := strings.IndexAny(pattern, operators); i > 0 {
+		return pattern[:i]
+	}
+	if i := strings.IndexAny(pattern, globs); i > 0 {
+		// The version is everything after the last hyphen
+		// before the glob, as in foo-devel-1.[0-9]*.
+		if j := strings.LastIndex(pattern[:i], "-"); j > 0 {
+			return pattern[:j]
+		}
+		return pattern[:i]
+	}
+	if name, ok := PkgName(pattern); ok {
+		return name
+	}
+	return pattern
+}
+
+// Match reports whether the pkgver satisfies the pattern.  The
+// pattern may be a bare name, an exact pkgver, a name with one or
+// more relational constraints such as foo>=1.0_1<2.0_1, a glob such
+// as foo-1.[0-9]* or foo-1.?_1, or csh style alternatives such as
+// foo-{1,2}*.
+// A pattern of the form foo~1.2 is a fuzzy match which accepts any
+// version whose leading components are 1.2.
+func Match(pkgver, pattern string) bool {
+	if pkgver == pattern {
+		return true
+	}
+
+	if i := strings.Index(pattern, "{"); i >= 0 {
+		return matchAlternatives(pkgver, pattern, i)
+	}
+
+	name, ok := PkgName(pkgver)
+	if !ok {
+		return false
+	}
+	ver, _ := PkgVersion(pkgver)
+
+	switch {
+	case strings.ContainsAny(pattern, "<>"):
+		i := strings.IndexAny(pattern, "<>")
+		return pattern[:i] == name && matchConstraints(ver, pattern[i:])
+	case strings.Contains(pattern, "~"):
+		i := strings.Index(pattern, "~")
+		return pattern[:i] == name && matchFuzzy(ver, pattern[i+1:])
+	case strings.ContainsAny(pattern, "*?["):
+		return PatternName(pattern) == name && matchGlob(pattern, pkgver)
+	}
+
+	if _, ok := PkgName(pattern); ok {
+		// Exact pkgvers were already checked above.
+		return false
+	}
+	return pattern == name
+}
+
+// Satisfies reports whether a package of the given name and version
+// satisfies the dependency pattern.
+func Satisfies(name, version, pattern string) bool {
+	return Match(name+"-"+version, pattern)
+}
+
+// matchConstraints checks a version against a list of relational
+// constraints such as >=1.0_1<2.0_1.
+func matchConstraints(ver, constraints string) bool {
+	for len(constraints) > 0 {
+		op := constraints[:1]
+		if len(constraints) > 1 && constraints[1] == '=' {
+			op = constraints[:2]
+		}
+		constraints = constraints[len(op):]
+
+		end := strings.IndexAny(constraints, "<>")
+		if end < 0 {
+			end = len(constraints)
+		}
+		want := constraints[:end]
+		constraints = constraints[end:]
+
+		c := Compare(ver, want)
+		switch op {
+		case "<":
+			if c >= 0 {
+				return false
+			}
+		case "<=":
+			if c > 0 {
+				return false
+			}
+		case ">":
+			if c <= 0 {
+				return false
+			}
+		case ">=":
+			if c < 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// matchFuzzy checks that the components of want are a prefix of the
+// components of ver.
+func matchFuzzy(ver, want string) bool {
+	pv, pw := parse(ver), parse(want)
+	if len(pw.components) > len(pv.components) {
+		return false
+	}
+	for i := range pw.components {
+		if pw.components[i] != pv.components[i] {
+			return false
+		}
+	}
+	return pw.revision == 0 || pw.revision == pv.revision
+}
+
+// matchAlternatives expands the first set of csh style alternatives
+// in the pattern and matches against each in turn.
+func matchAlternatives(pkgver, pattern string, open int) bool {
+	end := strings.Index(pattern[open:], "}")
+	if end < 0 {
+		return false
+	}
+	end += open
+
+	prefix, suffix := pattern[:open], pattern[end+1:]
+	for _, alt := range strings.Split(pattern[open+1:end], ",") {
+		if Match(pkgver, prefix+alt+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches s against a shell glob in the way fnmatch does
+// for xbps.  A * matches any run of characters, a ? matches exactly
+// one character, and a bracket expression such as [0-9] or [!a]
+// matches one character from, or not from, the set.
+func matchGlob(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			pattern = strings.TrimLeft(pattern, "*")
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchGlob(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		case '[':
+			if s == "" {
+				return false
+			}
+			n, ok := matchClass(pattern, s[0])
+			if n < 0 {
+				// An unterminated class is matched
+				// literally.
+				if s[0] != '[' {
+					return false
+				}
+				n = 1
+			} else if !ok {
+				return false
+			}
+			pattern, s = pattern[n:], s[1:]
+			continue
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return s == ""
+}
+
+// matchClass matches c against the bracket expression at the start
+// of the pattern.  It returns the length of the expression, or -1 if
+// it is not terminated, and whether c is in the set.
+func matchClass(pattern string, c byte) (int, bool) {
+	i := 1
+	negate := false
+	if i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^') {
+		negate = true
+		i++
+	}
+	found := false
+	for first := true; i < len(pattern); first = false {
+		if pattern[i] == ']' && !first {
+			return i + 1, found != negate
+		}
+		lo, hi := pattern[i], pattern[i]
+		if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+			hi = pattern[i+2]
+			i += 2
+		}
+		if lo <= c && c <= hi {
+			found = true
+		}
+		i++
+	}
+	return -1, false
+}
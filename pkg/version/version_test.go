@@ -0,0 +1,108 @@
+package version
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0_1", "1.0_1", 0},
+		{"1.0_1", "1.0_2", -1},
+		{"1.1_1", "1.0_9", 1},
+		{"1.10_1", "1.9_1", 1},
+		{"1.0rc1_1", "1.0_1", -1},
+		{"1.0alpha_1", "1.0beta_1", -1},
+		{"1.0a_1", "1.0_1", 1},
+		{"1.0.1_1", "1.0_1", 1},
+	}
+	for _, c := range cases {
+		if got := Compare(c.a, c.b); got != c.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestPkgName(t *testing.T) {
+	cases := []struct {
+		pkgver string
+		name   string
+		ok     bool
+	}{
+		{"foo-1.0_1", "foo", true},
+		{"libfoo-devel-1.2_1", "libfoo-devel", true},
+		{"gtk+3-devel-3.24.5_2", "gtk+3-devel", true},
+		{"foo", "", false},
+		{"foo-1.0", "", false},
+		{"libfoo-devel>=1.2_1", "", false},
+		{"foo-1.[0-9]_1", "", false},
+	}
+	for _, c := range cases {
+		name, ok := PkgName(c.pkgver)
+		if name != c.name || ok != c.ok {
+			t.Errorf("PkgName(%q) = %q, %v, want %q, %v", c.pkgver, name, ok, c.name, c.ok)
+		}
+	}
+}
+
+func TestPatternName(t *testing.T) {
+	cases := []struct {
+		pattern string
+		want    string
+	}{
+		{"foo", "foo"},
+		{"libfoo-devel", "libfoo-devel"},
+		{"foo-1.0_1", "foo"},
+		{"libfoo-devel-1.2_1", "libfoo-devel"},
+		{"foo>=1.0_1", "foo"},
+		{"libfoo-devel>=1.2_1", "libfoo-devel"},
+		{"gtk+3-devel>=3.0_1", "gtk+3-devel"},
+		{"foo-devel>=1.0_1<2.0_1", "foo-devel"},
+		{"foo-devel~1.2", "foo-devel"},
+		{"foo-1.[0-9]*", "foo"},
+		{"foo-devel-1.?_1", "foo-devel"},
+		{"foo-devel-{1,2}*", "foo-devel"},
+	}
+	for _, c := range cases {
+		if got := PatternName(c.pattern); got != c.want {
+			t.Errorf("PatternName(%q) = %q, want %q", c.pattern, got, c.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pkgver  string
+		pattern string
+		want    bool
+	}{
+		{"foo-1.0_1", "foo", true},
+		{"foo-1.0_1", "bar", false},
+		{"foo-1.0_1", "foo-1.0_1", true},
+		{"foo-1.0_1", "foo-1.0_2", false},
+		{"libfoo-devel-1.2_1", "libfoo-devel", true},
+		{"libfoo-devel-1.2_1", "libfoo", false},
+		{"libfoo-devel-1.2_1", "libfoo-devel>=1.2_1", true},
+		{"libfoo-devel-1.1_1", "libfoo-devel>=1.2_1", false},
+		{"libfoo-devel-1.2_1", "libfoo>=1.2_1", false},
+		{"foo-1.5_1", "foo>=1.0_1<2.0_1", true},
+		{"foo-2.0_1", "foo>=1.0_1<2.0_1", false},
+		{"foo-1.0_1", "foo>1.0_1", false},
+		{"foo-1.0_1", "foo<=1.0_1", true},
+		{"foo-1.2.3_1", "foo~1.2", true},
+		{"foo-1.3_1", "foo~1.2", false},
+		{"foo-1.5_1", "foo-1.[0-9]*", true},
+		{"foo-2.5_1", "foo-1.[0-9]*", false},
+		{"foo-1.5_1", "foo-1.?_1", true},
+		{"foo-1.55_1", "foo-1.?_1", false},
+		{"foo-1.5_1", "foo-1.[!5]_1", false},
+		{"foo-devel-1.5_1", "foo-1.*", false},
+		{"foo-2.0_1", "foo-{1,2}*", true},
+		{"foo-3.0_1", "foo-{1,2}*", false},
+	}
+	for _, c := range cases {
+		if got := Match(c.pkgver, c.pattern); got != c.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", c.pkgver, c.pattern, got, c.want)
+		}
+	}
+}